@@ -25,6 +25,9 @@ if ($args.Count -eq 0) {
     if (!(Test-Path -Path "src\github.com\tmc\scp")) {
         go get github.com/tmc/scp
     }
+    if (!(Test-Path -Path "src\github.com\fsnotify\fsnotify")) {
+        go get github.com/fsnotify/fsnotify
+    }
     # Linux/amd64 build
     $Env:GOOS = "linux"
     $Env:GOARCH = "amd64"
@@ -50,6 +53,7 @@ elseif ($args[0] -eq "clean") {
     Remove-Item -Force -Recurse "src\github.com\tmc"
     Remove-Item -Force -Recurse "src\github.com\kballard"
     Remove-Item -Force -Recurse "src\github.com\rwcarlsen"
+    Remove-Item -Force -Recurse "src\github.com\fsnotify"
 }
 elseif ($args[0] -eq "install") {
     # This bit is veeery customized for my system
@@ -8,30 +8,78 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bernarpa/photo/config"
 	"github.com/bernarpa/photo/exiftool"
+	"github.com/bernarpa/photo/fileops"
+	"github.com/bernarpa/photo/imaging"
+	"github.com/bernarpa/photo/mp4"
+	"github.com/bernarpa/photo/naming"
 	"github.com/bernarpa/photo/utils"
 	"github.com/rwcarlsen/goexif/exif"
 )
 
+// Version is the version of the cache format. The metadata of the files
+// listed in caches with an older version is read again, since their
+// entries lack information or have been computed differently.
+const Version = 2
+
 // Cache is the struct that represents a Photo cache JSON file.
 type Cache struct {
 	Target     string  `json:"target"`
+	Version    int     `json:"version,omitempty"`
 	LastUpdate int64   `json:"last_update"`
 	Photos     []Photo `json:"photos"`
 }
 
 // Photo represents a JPEG file entry of a JSON cache "photos" property.
 type Photo struct {
-	Path      string `json:"path"`
-	Size      int64  `json:"size"`
-	Timestamp int64  `json:"tstamp"`
-	Camera    string `json:"camera"`
-	Hash      string `json:"hash"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	ModTime   int64     `json:"mtime"`
+	Timestamp int64     `json:"tstamp"`
+	WallClock string    `json:"wallclock,omitempty"`
+	Offset    string    `json:"offset,omitempty"`
+	Camera    string    `json:"camera"`
+	Make      string    `json:"make,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	Location  *Location `json:"location,omitempty"`
+	// Width and Height are in pixels, Duration (videos) in seconds
+	Width       int     `json:"width,omitempty"`
+	Height      int     `json:"height,omitempty"`
+	Orientation int     `json:"orientation,omitempty"`
+	Lens        string  `json:"lens,omitempty"`
+	ISO         int     `json:"iso,omitempty"`
+	Exposure    string  `json:"exposure,omitempty"`
+	FNumber     float64 `json:"fnumber,omitempty"`
+	Duration    float64 `json:"duration,omitempty"`
+	Hash        string  `json:"hash"`
+	SHA256      string  `json:"sha256,omitempty"`
+	PHash       string  `json:"phash,omitempty"`
+	Verified    int64   `json:"verified,omitempty"`
+	SubSec      string  `json:"subsec,omitempty"`
+	Ignored     bool    `json:"ignored,omitempty"`
+}
+
+// Location is the GPS position where a photo has been taken.
+type Location struct {
+	Latitude  float64  `json:"lat"`
+	Longitude float64  `json:"lon"`
+	Altitude  *float64 `json:"alt,omitempty"`
+}
+
+// UpdateStats counts the changes found by UpdateDir with respect
+// to the previous version of a cache.
+type UpdateStats struct {
+	Added     int
+	Changed   int
+	Removed   int
+	Unchanged int
+	// Migrated are the unchanged files whose metadata has been read
+	// again because the previous cache had an older format
+	Migrated int
 }
 
 // HasExif checks whether the photo has Exif metadata.
@@ -39,66 +87,145 @@ func (photo *Photo) HasExif() bool {
 	return photo.Timestamp != 0 && photo.Camera != ""
 }
 
+// NoPHash is stored as perceptual hash of the images that couldn't be
+// decoded, so that they aren't retried until the file changes.
+const NoPHash = "-"
+
+// HasPHash checks whether the perceptual hash of the photo is available.
+func (photo *Photo) HasPHash() bool {
+	return photo.PHash != "" && photo.PHash != NoPHash
+}
+
+// NeedsPHash checks whether the perceptual hash of the photo has still to
+// be computed.
+func (photo *Photo) NeedsPHash() bool {
+	return photo.PHash == "" && !photo.Ignored && imaging.Supported(photo.Path)
+}
+
 // HeicToJPEG converts an HEIC photo to the JPEG format.
 // If the photo is not an HEIC file or if there is already a
 // file with the same name but .jpg extension this function
 // does nothing.
-func (photo *Photo) HeicToJPEG(et *exiftool.Exiftool) error {
+func (photo *Photo) HeicToJPEG(et *exiftool.Exiftool, target *config.Target, ops fileops.FileOps) error {
 	ext := filepath.Ext(photo.Path)
 	if strings.ToLower(ext) == ".heic" {
 		jpg := strings.TrimSuffix(photo.Path, ext) + ".jpg"
-		if _, err := os.Stat(jpg); !os.IsNotExist(err) {
+		if ops.Exists(jpg) {
 			return nil
 		}
-		err := utils.HeicToJPEG(photo.Path, jpg)
+		err := ops.HeicToJPEG(photo.Path, jpg)
 		if err != nil {
 			return err
 		}
 		// If the conversion was successful, analyze the newly created JPEG
 		jpgInfo, err := os.Stat(jpg)
 		if err != nil {
+			if ops.Exists(jpg) {
+				// The conversion is only planned (dry run): keep the HEIC metadata
+				ops.Remove(photo.Path)
+				photo.Path = jpg
+				return nil
+			}
 			return err
 		}
-		jpgPhoto, err := AnalyzePhoto(jpg, jpgInfo, et)
-		os.Remove(photo.Path)
+		jpgPhoto, err := AnalyzePhoto(jpg, jpgInfo, et, target)
+		ops.Remove(photo.Path)
 		if err != nil {
 			log.Printf("Warning: unable to analyze %s: %s\n", jpg, err.Error())
 			photo.Path = jpg
 		} else {
-			photo.Path = jpgPhoto.Path
-			photo.Size = jpgPhoto.Size
-			photo.Timestamp = jpgPhoto.Timestamp
-			photo.Camera = jpgPhoto.Camera
-			photo.Hash = jpgPhoto.Hash
+			*photo = jpgPhoto
 		}
 	}
 	return nil
 }
 
-// RenameToExif renames the photo according to the Exif timestamp
-// in the YYYY-MM-DD_HH-MM-SS.jpg format.
-func (photo *Photo) RenameToExif() error {
+// Kind returns "video" for videos and "photo" for everything else.
+func (photo *Photo) Kind() string {
+	if isSupportedVideo(photo.Path) {
+		return "video"
+	}
+	return "photo"
+}
+
+// NamingFields returns the values that fill the naming templates
+// for the photo. The counter is the progressive number of the photo
+// within the current operation.
+func (photo *Photo) NamingFields(counter int) naming.Fields {
+	name := filepath.Base(photo.Path)
+	return naming.Fields{
+		Time:    photo.Time(),
+		Make:    photo.Make,
+		Model:   photo.Model,
+		Camera:  photo.Camera,
+		Name:    strings.TrimSuffix(name, filepath.Ext(name)),
+		Counter: counter,
+		Kind:    photo.Kind(),
+	}
+}
+
+// RenameToExif renames the photo according to the Exif timestamp, using
+// the specified template (e.g. {YYYY}-{MM}-{DD}_{hh}-{mm}-{ss} for the
+// YYYY-MM-DD_HH-MM-SS.jpg format).
+func (photo *Photo) RenameToExif(template *naming.Template, counter int, ops fileops.FileOps) error {
 	if photo.Timestamp != 0 {
-		t := time.Unix(photo.Timestamp, 0)
-		timeStr := t.Format("2006-01-02_15-04-05")
-		newFileName := timeStr + strings.ToLower(filepath.Ext(photo.Path))
+		newFileName := template.Format(photo.NamingFields(counter)) + strings.ToLower(filepath.Ext(photo.Path))
 		newPath := filepath.Join(filepath.Dir(photo.Path), newFileName)
-		err := os.Rename(photo.Path, newPath)
+		err := photo.safeRename(newPath, ops)
 		if err != nil {
 			fmt.Printf("Warning: error while renaming %s to %s: %s\n", photo.Path, newPath, err.Error())
 			return err
 		}
-		photo.Path = newPath
 	}
 	return nil
 }
 
+// MoveTo moves the photo to the specified directory, keeping its
+// file name unless a different file with that name is already there.
+func (photo *Photo) MoveTo(dir string, ops fileops.FileOps) error {
+	return photo.safeRename(filepath.Join(dir, filepath.Base(photo.Path)), ops)
+}
+
+// safeRename renames the photo to newPath without overwriting other files.
+// If a file with the same content already exists the photo is left where
+// it is, otherwise a deterministic suffix is added to the file name: the
+// Exif sub-second timestamp if available, then _1, _2 and so on.
+func (photo *Photo) safeRename(newPath string, ops fileops.FileOps) error {
+	ext := filepath.Ext(newPath)
+	base := strings.TrimSuffix(newPath, ext)
+	candidate := newPath
+	for attempt := 1; ops.Exists(candidate); attempt++ {
+		if candidate == photo.Path {
+			return nil
+		}
+		if utils.SameContent(photo.Path, candidate) {
+			log.Printf("Warning: %s not renamed, %s has the same content\n", photo.Path, candidate)
+			return nil
+		}
+		if photo.SubSec != "" {
+			if attempt == 1 {
+				candidate = base + "_" + photo.SubSec + ext
+				continue
+			}
+			candidate = fmt.Sprintf("%s_%d%s", base, attempt-1, ext)
+		} else {
+			candidate = fmt.Sprintf("%s_%d%s", base, attempt, ext)
+		}
+	}
+	err := ops.Rename(photo.Path, candidate)
+	if err != nil {
+		return err
+	}
+	photo.Path = candidate
+	return nil
+}
+
 // Create returns an empty Cache.
 func Create(target *config.Target) *Cache {
 	if target != nil {
-		return &Cache{Target: target.Name, LastUpdate: time.Now().Unix()}
+		return &Cache{Target: target.Name, Version: Version, LastUpdate: time.Now().Unix()}
 	} else {
-		return &Cache{Target: "", LastUpdate: time.Now().Unix()}
+		return &Cache{Target: "", Version: Version, LastUpdate: time.Now().Unix()}
 	}
 }
 
@@ -125,6 +252,38 @@ func loadFile(path string) (*Cache, error) {
 	return &c, nil
 }
 
+// Save writes the cache to the specified path as gzipped JSON.
+func (myCache *Cache) Save(path string) error {
+	jsonContent, err := json.Marshal(myCache)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := gzip.NewWriter(f)
+	_, err = w.Write(jsonContent)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Index returns the photos of the cache indexed by path. The entries
+// that come from photoignore files are left out.
+func (myCache *Cache) Index() map[string]Photo {
+	index := make(map[string]Photo, len(myCache.Photos))
+	for _, photo := range myCache.Photos {
+		if !photo.Ignored {
+			index[photo.Path] = photo
+		}
+	}
+	return index
+}
+
 // Load loads a cache from a cache file. If the cache file doesn't exist
 // exist or if the cache is too old, the cache will be updated.
 func Load(conf *config.Config, target *config.Target) (*Cache, error) {
@@ -132,79 +291,187 @@ func Load(conf *config.Config, target *config.Target) (*Cache, error) {
 	return loadFile(cachePath)
 }
 
+// exifString returns the value of a string Exif tag, or "" if it's missing.
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil || tag == nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
 // AnalyzePhoto analyizes a JPEG files, including the Exif metadata.
-func AnalyzePhoto(path string, info os.FileInfo, et *exiftool.Exiftool) (Photo, error) {
-	photo := Photo{Path: path, Size: info.Size()}
-	if isSupportedImage(path) {
-		// Use the fast Go Exif implementation for images
-		f, err := os.Open(path)
+// The time zone of the target (see config.Target.GetLocation) is used
+// when the metadata doesn't tell where the photo has been taken.
+func AnalyzePhoto(path string, info os.FileInfo, et *exiftool.Exiftool, target *config.Target) (Photo, error) {
+	photo := Photo{Path: path, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	// The content hash tells apart different photos with the same
+	// metadata (e.g. edited copies or burst shots), the MD5 is computed
+	// in the same pass for the photos without metadata
+	sha256Hash, md5Hash, err := utils.SHA256AndMD5(path)
+	if err != nil {
+		return photo, err
+	}
+	photo.SHA256 = sha256Hash
+	err = photo.readMetadata(et, target)
+	if err != nil {
+		return photo, err
+	}
+	// The ideal hash is camera + wall clock time, which doesn't depend
+	// on the time zone of the system
+	if photo.HasExif() {
+		photo.Hash = photo.WallClock + "|" + photo.Camera
+	} else {
+		// If that doesn't work, use the file MD5
+		photo.Hash = md5Hash
+	}
+	return photo, nil
+}
+
+// migratePhoto analyzes again the metadata of a file that hasn't changed
+// since it has been added to a cache with an older format. The content
+// hashes, the last verification and the perceptual hash are kept, so
+// that the file doesn't need to be read again.
+func migratePhoto(old Photo, et *exiftool.Exiftool, target *config.Target) (Photo, error) {
+	photo := Photo{
+		Path:     old.Path,
+		Size:     old.Size,
+		ModTime:  old.ModTime,
+		SHA256:   old.SHA256,
+		PHash:    old.PHash,
+		Verified: old.Verified,
+		Ignored:  old.Ignored,
+	}
+	err := photo.readMetadata(et, target)
+	if err != nil {
+		return photo, err
+	}
+	if photo.HasExif() {
+		photo.Hash = photo.WallClock + "|" + photo.Camera
+	} else if !old.HasExif() {
+		// The hash of the photos without metadata was already the MD5
+		photo.Hash = old.Hash
+	} else {
+		photo.Hash, err = utils.MD5(photo.Path)
 		if err != nil {
-			//log.Printf("Error opening %s: %s", path, err.Error())
 			return photo, err
 		}
+	}
+	return photo, nil
+}
+
+// readMetadata reads the camera, the time when the photo has been taken
+// and the other Exif metadata, using the Go implementations for images
+// and the common videos and exiftool for the other files.
+func (photo *Photo) readMetadata(et *exiftool.Exiftool, target *config.Target) error {
+	if isSupportedImage(photo.Path) {
+		// Use the fast Go Exif implementation for images
+		f, err := os.Open(photo.Path)
+		if err != nil {
+			//log.Printf("Error opening %s: %s", photo.Path, err.Error())
+			return err
+		}
 		defer f.Close()
 		x, err := exif.Decode(f)
 		if err == nil {
-			tm, err := x.DateTime()
-			if err == nil {
-				photo.Timestamp = tm.Unix()
-			}
-			make := ""
-			camMake, err := x.Get(exif.Make)
-			if err == nil && camMake != nil {
-				make, err = camMake.StringVal()
-				if err != nil {
-					make = ""
-				}
-			}
-			model := ""
-			camModel, err := x.Get(exif.Model)
-			if err == nil && camModel != nil {
-				model, err = camModel.StringVal()
-				if err != nil {
-					model = ""
-				}
+			photo.Make = exifString(x, exif.Make)
+			photo.Model = exifString(x, exif.Model)
+			photo.Camera = strings.TrimSpace(photo.Make + " " + photo.Model)
+			photo.SubSec = exifString(x, exif.SubSecTimeOriginal)
+			photo.readExifMetadata(x, f)
+			loc := target.GetLocation(photo.Camera)
+			if wall, _, ok := parseExifTime(exifString(x, exif.DateTimeOriginal)); ok {
+				photo.setWallClock(wall, normalizeOffset(exifString(x, OffsetTimeOriginal)), loc)
+			} else if wall, _, ok := parseExifTime(exifString(x, exif.DateTime)); ok {
+				photo.setWallClock(wall, normalizeOffset(exifString(x, OffsetTime)), loc)
 			}
-			photo.Camera = strings.TrimSpace(make + " " + model)
+		}
+	} else if meta, err := mp4.Parse(photo.Path); err == nil && !meta.Time().IsZero() {
+		// Use the Go parser for the common video formats
+		photo.Make = meta.Make
+		photo.Model = meta.Model
+		photo.Camera = strings.TrimSpace(meta.Make + " " + meta.Model)
+		photo.readVideoMetadata(meta)
+		if !meta.CreationDate.IsZero() {
+			// The Apple creation date has the UTC offset
+			_, offset := meta.CreationDate.Zone()
+			photo.setInstant(meta.CreationDate, time.FixedZone("", offset))
+			photo.Offset = meta.CreationDate.Format("-07:00")
+		} else {
+			photo.setInstant(meta.Created, target.GetLocation(photo.Camera))
 		}
 	} else {
-		// Use exiftool for videos
-		out, err := et.Parse(path)
+		// Fall back to exiftool for the other videos
+		out, err := et.Parse(photo.Path)
 		if err != nil {
-			return photo, err
+			return err
 		}
-		photo.Timestamp = out.Timestamp
+		photo.Make = strings.TrimSpace(out.Make)
+		photo.Model = strings.TrimSpace(out.Model)
 		photo.Camera = strings.TrimSpace(out.Make + " " + out.Model)
-	}
-	// The ideal hash is camera + timestamp
-	if photo.Timestamp != 0 && photo.Camera != "" {
-		photo.Hash = strconv.FormatInt(photo.Timestamp, 10) + "|" + photo.Camera
-	} else {
-		// If that doesn't work, try with the file MD5
-		var err error
-		photo.Hash, err = utils.MD5(photo.Path)
-		if err != nil {
-			// In case of MD5 error, use the file name as hash
-			photo.Hash = filepath.Base(photo.Path)
+		photo.SubSec = strings.TrimSpace(string(out.SubSecTimeOriginal))
+		photo.readExiftoolMetadata(out)
+		loc := target.GetLocation(photo.Camera)
+		if wall, offset, ok := parseExifTime(out.CreationDate); ok && offset != "" {
+			photo.setWallClock(wall, offset, loc)
+		} else if wall, offset, ok := parseExifTime(out.DateTimeOriginal); ok {
+			if offset == "" {
+				offset = normalizeOffset(out.OffsetTimeOriginal)
+			}
+			photo.setWallClock(wall, offset, loc)
+		} else if instant, _, ok := parseExifTime(out.MediaCreateDate); ok {
+			// QuickTime dates are in UTC
+			photo.setInstant(instant, loc)
 		}
 	}
-	return photo, nil
+	return nil
 }
 
 type workerInput struct {
-	path string
-	info os.FileInfo
+	path    string
+	info    os.FileInfo
+	changed bool
+	// old is the entry to migrate, if the file hasn't changed
+	old *Photo
 }
 
 type workerOutput struct {
-	photo Photo
-	err   error
+	photo    Photo
+	changed  bool
+	migrated bool
+	err      error
 }
 
-func workerAnalyzePhoto(id int, jobs <-chan workerInput, results chan<- workerOutput, et *exiftool.Exiftool) {
+func workerAnalyzePhoto(id int, jobs <-chan workerInput, results chan<- workerOutput, et *exiftool.Exiftool, target *config.Target) {
 	for j := range jobs {
-		photo, err := AnalyzePhoto(j.path, j.info, et)
-		results <- workerOutput{photo, err}
+		if j.old != nil {
+			photo, err := migratePhoto(*j.old, et, target)
+			results <- workerOutput{photo, false, true, err}
+			continue
+		}
+		photo, err := AnalyzePhoto(j.path, j.info, et, target)
+		results <- workerOutput{photo, j.changed, false, err}
+	}
+}
+
+// upgradeIgnored converts the hash of an entry of a photoignore file
+// written before wall clock times were introduced. The timestamps of the
+// videos were absolute times, so their wall clock is in the time zone of
+// the target, whereas the Exif timestamps of the images were computed in
+// the local time zone of the system: the conversion is exact only if the
+// file was written on a system with the same time zone.
+func upgradeIgnored(photo *Photo, target *config.Target) {
+	if photo.HasExif() && photo.WallClock == "" {
+		loc := time.Local
+		if isSupportedVideo(photo.Path) {
+			loc = target.GetLocation(photo.Camera)
+		}
+		photo.WallClock = time.Unix(photo.Timestamp, 0).In(loc).Format(WallClockLayout)
+		photo.Hash = photo.WallClock + "|" + photo.Camera
 	}
 }
 
@@ -229,34 +496,79 @@ func isSupportedVideo(path string) bool {
 		ext == ".mp4"
 }
 
+// IsSupported checks whether a file is a supported photo or video.
+func IsSupported(path string) bool {
+	return isSupportedImage(path) || isSupportedVideo(path)
+}
+
 func isPhotoIgnore(path string) bool {
 	fileName := filepath.Base(path)
 	return strings.HasPrefix(fileName, "photoignore_") && strings.HasSuffix(fileName, ".json.gz")
 }
 
 // AnalyzeDir fills the cache with data about the JPEG images contained in the
-// specified directory.
-func (myCache *Cache) AnalyzeDir(dir string, numWorkers int, et *exiftool.Exiftool, ignores []string) error {
+// specified directory, skipping the paths ignored by the target (if any).
+func (myCache *Cache) AnalyzeDir(dir string, numWorkers int, et *exiftool.Exiftool, target *config.Target) error {
+	return myCache.UpdateDir(dir, numWorkers, et, target, nil, Version, &UpdateStats{})
+}
+
+// UpdateDir works like AnalyzeDir, but it reuses the entries of the previous
+// cache (as returned by Index) whose path, size and modification time haven't
+// changed, so that only new or modified files are analyzed. The entries found
+// in dir are deleted from previous, therefore after updating all the
+// directories previous contains only the files that have been removed.
+// If previousVersion, the format version of the previous cache, is older
+// than Version, the metadata of the unchanged files is read again, but
+// their content hashes are kept (see migratePhoto).
+func (myCache *Cache) UpdateDir(dir string, numWorkers int, et *exiftool.Exiftool, target *config.Target, previous map[string]Photo, previousVersion int, stats *UpdateStats) error {
+	var ignores []string
+	if target != nil {
+		ignores = target.Ignore
+	}
 	var inputs []workerInput
 	err := filepath.Walk(dir,
 		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
+			if info.IsDir() && fileops.IsTrash(path) {
+				return filepath.SkipDir
+			}
 			for _, ignore := range ignores {
 				if strings.Contains(path, ignore) {
 					return nil
 				}
 			}
 			if isSupportedImage(path) || isSupportedVideo(path) {
-				inputs = append(inputs, workerInput{path, info})
+				old, exists := previous[path]
+				if exists {
+					delete(previous, path)
+					// Entries created before content hashes were introduced
+					// are analyzed again
+					if old.Size == info.Size() && old.ModTime == info.ModTime().UnixNano() && old.SHA256 != "" {
+						if previousVersion < Version {
+							inputs = append(inputs, workerInput{path, info, false, &old})
+							return nil
+						}
+						myCache.Photos = append(myCache.Photos, old)
+						stats.Unchanged++
+						return nil
+					}
+				}
+				inputs = append(inputs, workerInput{path, info, exists, nil})
 			}
 			if isPhotoIgnore(path) {
 				photoIgnore, err := loadFile(path)
 				if err != nil {
 					log.Printf("Error while loading photoignore file %s: %s\n", path, err.Error())
 				} else {
-					myCache.Photos = append(myCache.Photos, photoIgnore.Photos...)
+					for _, photo := range photoIgnore.Photos {
+						if photoIgnore.Version < 1 {
+							upgradeIgnored(&photo, target)
+						}
+						photo.Ignored = true
+						myCache.Photos = append(myCache.Photos, photo)
+					}
 				}
 			}
 			return nil
@@ -264,11 +576,34 @@ func (myCache *Cache) AnalyzeDir(dir string, numWorkers int, et *exiftool.Exifto
 	if err != nil {
 		return err
 	}
+	myCache.analyze(inputs, numWorkers, et, target, stats)
+	return nil
+}
+
+// AnalyzeFiles adds to the cache the data about the specified files,
+// e.g. these that have just been found in a directory. The files that
+// can't be read are skipped.
+func (myCache *Cache) AnalyzeFiles(paths []string, numWorkers int, et *exiftool.Exiftool, target *config.Target) {
+	var inputs []workerInput
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("Err: %s\n", err.Error())
+			continue
+		}
+		inputs = append(inputs, workerInput{path, info, false, nil})
+	}
+	myCache.analyze(inputs, numWorkers, et, target, &UpdateStats{})
+}
+
+// analyze adds to the cache the data about the specified files, using
+// numWorkers goroutines.
+func (myCache *Cache) analyze(inputs []workerInput, numWorkers int, et *exiftool.Exiftool, target *config.Target, stats *UpdateStats) {
 	numJobs := len(inputs)
 	jobs := make(chan workerInput, numJobs)
 	results := make(chan workerOutput, numJobs)
 	for w := 0; w < numWorkers; w++ {
-		go workerAnalyzePhoto(w, jobs, results, et)
+		go workerAnalyzePhoto(w, jobs, results, et, target)
 	}
 	for j := 0; j < numJobs; j++ {
 		jobs <- inputs[j]
@@ -281,7 +616,110 @@ func (myCache *Cache) AnalyzeDir(dir string, numWorkers int, et *exiftool.Exifto
 			log.Printf("Err: %s\n", output.err.Error())
 			continue
 		}
+		if output.migrated {
+			stats.Migrated++
+		} else if output.changed {
+			stats.Changed++
+		} else {
+			stats.Added++
+		}
 		myCache.Photos = append(myCache.Photos, output.photo)
 	}
-	return nil
+}
+
+// ThumbnailPath returns the path of the thumbnail of the photo in the
+// specified thumbnails directory. Thumbnails are named after the SHA-256
+// hash of the photo, so that a photo that is moved keeps its thumbnail
+// and a photo that is modified gets a new one. It returns "" if the
+// hash is unknown.
+func (photo *Photo) ThumbnailPath(dir string) string {
+	if len(photo.SHA256) < 2 {
+		return ""
+	}
+	return filepath.Join(dir, photo.SHA256[:2], photo.SHA256+".jpg")
+}
+
+// HasThumbnail checks whether a thumbnail can be generated for the photo.
+func (photo *Photo) HasThumbnail() bool {
+	return !photo.Ignored && photo.SHA256 != "" && imaging.Supported(photo.Path)
+}
+
+// UpdateThumbnails generates the missing thumbnails of the photos in the
+// specified directory, using numWorkers goroutines. It returns the number
+// of thumbnails generated and of failures.
+func (myCache *Cache) UpdateThumbnails(dir string, size int, numWorkers int) (int, int) {
+	jobs := make(chan int, len(myCache.Photos))
+	queued := make(map[string]bool)
+	for i := range myCache.Photos {
+		photo := &myCache.Photos[i]
+		if !photo.HasThumbnail() || queued[photo.SHA256] {
+			continue
+		}
+		if _, err := os.Stat(photo.ThumbnailPath(dir)); err == nil {
+			continue
+		}
+		// Identical files share the thumbnail
+		queued[photo.SHA256] = true
+		jobs <- i
+	}
+	numJobs := len(jobs)
+	close(jobs)
+	done := make(chan bool, numJobs)
+	for w := 0; w < numWorkers; w++ {
+		go func() {
+			for i := range jobs {
+				photo := &myCache.Photos[i]
+				err := imaging.WriteThumbnail(photo.Path, photo.ThumbnailPath(dir), size, photo.Orientation)
+				if err != nil {
+					log.Printf("Unable to generate the thumbnail of %s: %s\n", photo.Path, err.Error())
+				}
+				done <- err == nil
+			}
+		}()
+	}
+	created := 0
+	for j := 0; j < numJobs; j++ {
+		if <-done {
+			created++
+		}
+	}
+	return created, numJobs - created
+}
+
+// UpdatePHashes computes the perceptual hash of the images of the cache
+// that don't have one yet, using numWorkers goroutines. It returns the
+// number of hashes that have been computed. The images that can't be
+// decoded are marked with NoPHash.
+func (myCache *Cache) UpdatePHashes(numWorkers int) int {
+	jobs := make(chan int, len(myCache.Photos))
+	for i := range myCache.Photos {
+		if myCache.Photos[i].NeedsPHash() {
+			jobs <- i
+		}
+	}
+	numJobs := len(jobs)
+	close(jobs)
+	done := make(chan bool, numJobs)
+	for w := 0; w < numWorkers; w++ {
+		go func() {
+			for i := range jobs {
+				hash, err := imaging.FileDHash(myCache.Photos[i].Path)
+				if err != nil {
+					log.Printf("Unable to compute the perceptual hash of %s: %s\n", myCache.Photos[i].Path, err.Error())
+					myCache.Photos[i].PHash = NoPHash
+					done <- false
+					continue
+				}
+				myCache.Photos[i].PHash = imaging.FormatHash(hash)
+				done <- true
+			}
+		}()
+	}
+	computed := 0
+	for j := 0; j < numJobs; j++ {
+		if <-done {
+			computed++
+		}
+	}
+	return computed
 }
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateDirMigration(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "no_exif.jpg")
+	if err := ioutil.WriteFile(path, []byte("not really a JPEG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The entry of a cache with an older format, whose hashes must be
+	// kept although they don't match the content of the file
+	old := Photo{
+		Path:     path,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UnixNano(),
+		Hash:     "old md5",
+		SHA256:   "old sha256",
+		PHash:    NoPHash,
+		Verified: 1600000000,
+		Width:    -1,
+	}
+	tests := []struct {
+		name            string
+		previousVersion int
+		modTime         int64
+		want            UpdateStats
+		keep            bool
+	}{
+		{"current format", Version, old.ModTime, UpdateStats{Unchanged: 1}, true},
+		{"older format", Version - 1, old.ModTime, UpdateStats{Migrated: 1}, true},
+		{"changed file", Version - 1, old.ModTime + 1, UpdateStats{Changed: 1}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := old
+			entry.ModTime = test.modTime
+			previous := map[string]Photo{path: entry}
+			myCache := &Cache{Version: Version}
+			var stats UpdateStats
+			err := myCache.UpdateDir(dir, 1, nil, nil, previous, test.previousVersion, &stats)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats != test.want {
+				t.Errorf("stats = %+v, want %+v", stats, test.want)
+			}
+			if len(previous) != 0 || len(myCache.Photos) != 1 {
+				t.Fatalf("%d entries left in previous, %d photos in the cache", len(previous), len(myCache.Photos))
+			}
+			photo := myCache.Photos[0]
+			kept := photo.Hash == old.Hash && photo.SHA256 == old.SHA256 && photo.PHash == old.PHash && photo.Verified == old.Verified
+			if kept != test.keep {
+				t.Errorf("hashes kept = %v, want %v: %+v", kept, test.keep, photo)
+			}
+			if photo.ModTime != info.ModTime().UnixNano() {
+				t.Errorf("ModTime = %d, want %d", photo.ModTime, info.ModTime().UnixNano())
+			}
+			// The metadata is read again, unless the cache is up to date
+			if reread := photo.Width != old.Width; reread != (test.previousVersion < Version) {
+				t.Errorf("Width = %d after updating a version %d cache", photo.Width, test.previousVersion)
+			}
+		})
+	}
+}
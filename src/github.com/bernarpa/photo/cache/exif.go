@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"bytes"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Exif 2.31 time zone tags, which goexif doesn't know.
+const (
+	OffsetTime          exif.FieldName = "OffsetTime"
+	OffsetTimeOriginal  exif.FieldName = "OffsetTimeOriginal"
+	OffsetTimeDigitized exif.FieldName = "OffsetTimeDigitized"
+)
+
+var offsetFields = map[uint16]exif.FieldName{
+	0x9010: OffsetTime,
+	0x9011: OffsetTimeOriginal,
+	0x9012: OffsetTimeDigitized,
+}
+
+// offsetParser loads the time zone tags of the Exif sub-IFD.
+type offsetParser struct{}
+
+func (offsetParser) Parse(x *exif.Exif) error {
+	tag, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, 0); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(dir, offsetFields, false)
+	return nil
+}
+
+func init() {
+	exif.RegisterParsers(offsetParser{})
+}
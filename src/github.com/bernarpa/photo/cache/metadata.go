@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bernarpa/photo/exiftool"
+	"github.com/bernarpa/photo/mp4"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// exifInt returns the value of an integer Exif tag, or 0 if it's missing.
+func exifInt(x *exif.Exif, name exif.FieldName) int {
+	tag, err := x.Get(name)
+	if err != nil || tag == nil {
+		return 0
+	}
+	value, err := tag.Int(0)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// exifFloat returns the value of a rational Exif tag.
+func exifFloat(x *exif.Exif, name exif.FieldName) (float64, bool) {
+	tag, err := x.Get(name)
+	if err != nil || tag == nil {
+		return 0, false
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || den == 0 {
+		return 0, false
+	}
+	return float64(num) / float64(den), true
+}
+
+// formatExposure formats an exposure time in seconds as photographers
+// do, e.g. 1/250, 0.4 or 2.5. The fraction is used only when the
+// denominator is an integer, allowing for the rounding of the values
+// written as decimals by exiftool.
+func formatExposure(seconds float64) string {
+	if seconds <= 0 {
+		return ""
+	}
+	if seconds < 1 {
+		inverse := 1 / seconds
+		if rounded := math.Round(inverse); rounded >= 2 && math.Abs(inverse-rounded) <= 0.01*inverse {
+			return fmt.Sprintf("1/%.0f", rounded)
+		}
+		return strconv.FormatFloat(seconds, 'g', 3, 64)
+	}
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
+
+// textFloat parses a numeric value written by exiftool.
+func textFloat(value exiftool.Text) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(string(value)), 64)
+	return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// textInt parses an integer value written by exiftool.
+func textInt(value exiftool.Text) int {
+	f, _ := textFloat(value)
+	return int(f)
+}
+
+// readExifMetadata reads the GPS position, the shooting settings and
+// the size of a photo from its Exif tags. The size is read from the
+// image itself when possible, since the Exif one isn't updated by all
+// the editors.
+func (photo *Photo) readExifMetadata(x *exif.Exif, r io.ReadSeeker) {
+	if lat, lon, err := x.LatLong(); err == nil {
+		photo.Location = &Location{Latitude: lat, Longitude: lon}
+		if alt, ok := exifFloat(x, exif.GPSAltitude); ok {
+			if exifInt(x, exif.GPSAltitudeRef) == 1 {
+				alt = -alt
+			}
+			photo.Location.Altitude = &alt
+		}
+	}
+	photo.Orientation = exifInt(x, exif.Orientation)
+	photo.Lens = exifString(x, exif.LensModel)
+	photo.ISO = exifInt(x, exif.ISOSpeedRatings)
+	if exposure, ok := exifFloat(x, exif.ExposureTime); ok {
+		photo.Exposure = formatExposure(exposure)
+	}
+	if fNumber, ok := exifFloat(x, exif.FNumber); ok {
+		photo.FNumber = fNumber
+	}
+	if _, err := r.Seek(0, io.SeekStart); err == nil {
+		if config, _, err := image.DecodeConfig(r); err == nil {
+			photo.Width, photo.Height = config.Width, config.Height
+			return
+		}
+	}
+	photo.Width = exifInt(x, exif.PixelXDimension)
+	photo.Height = exifInt(x, exif.PixelYDimension)
+}
+
+// readVideoMetadata reads the GPS position, the size and the duration
+// of a video.
+func (photo *Photo) readVideoMetadata(meta *mp4.Metadata) {
+	if meta.HasLocation {
+		photo.Location = &Location{Latitude: meta.Latitude, Longitude: meta.Longitude}
+		if meta.HasAltitude {
+			alt := meta.Altitude
+			photo.Location.Altitude = &alt
+		}
+	}
+	photo.Width, photo.Height = meta.Width, meta.Height
+	photo.Duration = meta.Duration.Round(time.Millisecond).Seconds()
+}
+
+// readExiftoolMetadata reads the GPS position, the shooting settings,
+// the size and the duration of a file from the exiftool output.
+func (photo *Photo) readExiftoolMetadata(out *exiftool.Output) {
+	lat, okLat := textFloat(out.GPSLatitude)
+	lon, okLon := textFloat(out.GPSLongitude)
+	if okLat && okLon {
+		photo.Location = &Location{Latitude: lat, Longitude: lon}
+		if alt, ok := textFloat(out.GPSAltitude); ok {
+			photo.Location.Altitude = &alt
+		}
+	}
+	photo.Width = textInt(out.ImageWidth)
+	photo.Height = textInt(out.ImageHeight)
+	photo.Orientation = textInt(out.Orientation)
+	photo.Lens = strings.TrimSpace(string(out.LensModel))
+	photo.ISO = textInt(out.ISO)
+	if exposure, ok := textFloat(out.ExposureTime); ok {
+		photo.Exposure = formatExposure(exposure)
+	}
+	photo.FNumber, _ = textFloat(out.FNumber)
+	if duration, ok := textFloat(out.Duration); ok {
+		photo.Duration = math.Round(duration*1000) / 1000
+	}
+}
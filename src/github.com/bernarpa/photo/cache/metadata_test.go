@@ -0,0 +1,31 @@
+package cache
+
+import "testing"
+
+func TestFormatExposure(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{0, ""},
+		{-1, ""},
+		{1.0 / 250, "1/250"},
+		{1.0 / 8000, "1/8000"},
+		// Decimal values written by exiftool
+		{0.0166666666666667, "1/60"},
+		{0.333, "1/3"},
+		{0.5, "1/2"},
+		{0.4, "0.4"},
+		{0.625, "0.625"},
+		{10.0 / 13, "0.769"},
+		{0.99, "0.99"},
+		{1, "1"},
+		{2.5, "2.5"},
+		{30, "30"},
+	}
+	for _, test := range tests {
+		if got := formatExposure(test.seconds); got != test.want {
+			t.Errorf("formatExposure(%v) = %q, want %q", test.seconds, got, test.want)
+		}
+	}
+}
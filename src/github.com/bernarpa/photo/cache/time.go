@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WallClockLayout is the layout of Photo.WallClock.
+const WallClockLayout = "2006-01-02T15:04:05"
+
+// exifTime matches an Exif date and time, e.g. 2021:07:14 18:30:05,
+// optionally followed by sub-seconds and a UTC offset as written by
+// exiftool, e.g. 2021:07:14 18:30:05.123+02:00.
+var exifTime = regexp.MustCompile(`^(\d{4}):(\d{2}):(\d{2}) (\d{2}):(\d{2}):(\d{2})(?:\.\d+)?\s*(Z|[+-]\d{2}:?\d{2})?$`)
+
+// utcOffset matches a UTC offset such as +02:00 or -0530.
+var utcOffset = regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})$`)
+
+// parseExifTime parses an Exif date and time. It returns the wall clock
+// time (in UTC, as a plain date and time) and the UTC offset normalized
+// as +hh:mm, if the value includes it.
+func parseExifTime(value string) (time.Time, string, bool) {
+	m := exifTime.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return time.Time{}, "", false
+	}
+	var parts [6]int
+	for i := range parts {
+		parts[i], _ = strconv.Atoi(m[i+1])
+	}
+	wall := time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, time.UTC)
+	// Cameras write 0000:00:00 00:00:00 when the date isn't set
+	if parts[0] == 0 || wall.Month() != time.Month(parts[1]) || wall.Day() != parts[2] {
+		return time.Time{}, "", false
+	}
+	return wall, normalizeOffset(m[7]), true
+}
+
+// normalizeOffset normalizes a UTC offset as +hh:mm, or returns
+// "" if it isn't valid.
+func normalizeOffset(offset string) string {
+	offset = strings.TrimSpace(offset)
+	if offset == "Z" {
+		return "+00:00"
+	}
+	m := utcOffset.FindStringSubmatch(offset)
+	if m == nil {
+		return ""
+	}
+	return m[1] + m[2] + ":" + m[3]
+}
+
+// offsetSeconds returns the number of seconds of a normalized UTC offset.
+func offsetSeconds(offset string) int {
+	m := utcOffset.FindStringSubmatch(offset)
+	if m == nil {
+		return 0
+	}
+	hours, _ := strconv.Atoi(m[2])
+	minutes, _ := strconv.Atoi(m[3])
+	seconds := hours*3600 + minutes*60
+	if m[1] == "-" {
+		return -seconds
+	}
+	return seconds
+}
+
+// setWallClock sets the capture time of the photo from the wall clock
+// time shown by the camera. If the UTC offset isn't known the wall clock
+// time is interpreted in loc.
+func (photo *Photo) setWallClock(wall time.Time, offset string, loc *time.Location) {
+	photo.WallClock = wall.Format(WallClockLayout)
+	photo.Offset = offset
+	if offset != "" {
+		photo.Timestamp = wall.Unix() - int64(offsetSeconds(offset))
+	} else {
+		photo.Timestamp = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc).Unix()
+	}
+}
+
+// setInstant sets the capture time of the photo from an absolute time,
+// e.g. the UTC creation time of a video, whose wall clock time is
+// computed in loc.
+func (photo *Photo) setInstant(instant time.Time, loc *time.Location) {
+	photo.Timestamp = instant.Unix()
+	photo.WallClock = instant.In(loc).Format(WallClockLayout)
+	photo.Offset = ""
+}
+
+// Time returns the capture time of the photo, in the time zone where it
+// has been taken when known, so that its date and time are the ones shown
+// by the camera. The entries without wall clock time (cache files written
+// by older versions) get the local time zone of the system.
+func (photo *Photo) Time() time.Time {
+	instant := time.Unix(photo.Timestamp, 0)
+	if photo.WallClock == "" {
+		return instant
+	}
+	wall, err := time.Parse(WallClockLayout, photo.WallClock)
+	if err != nil {
+		return instant
+	}
+	offset := int(wall.Unix() - photo.Timestamp)
+	name := photo.Offset
+	if name == "" {
+		sign, seconds := "+", offset
+		if offset < 0 {
+			sign, seconds = "-", -offset
+		}
+		name = fmt.Sprintf("%s%02d:%02d", sign, seconds/3600, seconds%3600/60)
+	}
+	return instant.In(time.FixedZone(name, offset))
+}
+
+// Shift moves the capture time of the photo by the specified offset, as
+// done by exiftool.ShiftDates on the file, and updates its hash.
+func (photo *Photo) Shift(offset time.Duration) {
+	if photo.Timestamp == 0 {
+		return
+	}
+	photo.Timestamp += int64(offset / time.Second)
+	if wall, err := time.Parse(WallClockLayout, photo.WallClock); err == nil {
+		photo.WallClock = wall.Add(offset).Format(WallClockLayout)
+	}
+	if photo.HasExif() {
+		photo.Hash = photo.WallClock + "|" + photo.Camera
+	}
+}
@@ -2,10 +2,15 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
 
+	"github.com/bernarpa/photo/naming"
 	"github.com/bernarpa/photo/utils"
 )
 
@@ -15,23 +20,59 @@ type Config struct {
 	Targets       []Target `json:"targets"`
 	Perl          string   `json:"perl"`
 	PathSeparator string   `json:"path_separator"`
+	TimeZone      string   `json:"time_zone"`
 }
 
 // Target is a photo collection to be manage through Photo. it can be local or accessible via SSH.
 type Target struct {
-	Name             string   `json:"name"`
-	TargetType       string   `json:"target_type"`
-	WorkDir          string   `json:"work_dir"`
-	Perl             string   `json:"perl"`
-	SSHPathSeparator string   `json:"ssh_path_separator"`
-	SSHExe           string   `json:"ssh_exe"`
-	SSHHost          string   `json:"ssh_host"`
-	SSHPort          string   `json:"ssh_port"`
-	SSHUser          string   `json:"ssh_user"`
-	SSHPassword      string   `json:"ssh_password"`
-	Collections      []string `json:"collections"`
-	Cameras          []string `json:"cameras"`
-	Ignore           []string `json:"ignore"`
+	Name             string            `json:"name"`
+	TargetType       string            `json:"target_type"`
+	WorkDir          string            `json:"work_dir"`
+	Perl             string            `json:"perl"`
+	SSHPathSeparator string            `json:"ssh_path_separator"`
+	SSHExe           string            `json:"ssh_exe"`
+	SSHHost          string            `json:"ssh_host"`
+	SSHPort          string            `json:"ssh_port"`
+	SSHUser          string            `json:"ssh_user"`
+	SSHPassword      string            `json:"ssh_password"`
+	SSHKeyFiles      []string          `json:"ssh_key_files"`
+	SSHKeyPassphrase string            `json:"ssh_key_passphrase"`
+	SSHAgent         bool              `json:"ssh_agent"`
+	SSHKnownHosts    string            `json:"ssh_known_hosts"`
+	Collections      []string          `json:"collections"`
+	Cameras          []string          `json:"cameras"`
+	Ignore           []string          `json:"ignore"`
+	RenameTemplate   string            `json:"rename_template"`
+	FolderTemplate   string            `json:"folder_template"`
+	ImportDir        string            `json:"import_dir"`
+	TimeZone         string            `json:"time_zone"`
+	CameraTimeZones  map[string]string `json:"camera_time_zones"`
+	location         *time.Location
+	cameraLocations  map[string]*time.Location
+}
+
+// defaultLocation is the time zone used when neither the camera nor the
+// target has one, or there is no target at all (e.g. for fix): the
+// time_zone of the configuration, or the local time zone of the system.
+var defaultLocation = time.Local
+
+// fixedZone matches the time zones specified as an offset from UTC,
+// e.g. +02:00 or -0530.
+var fixedZone = regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})$`)
+
+// ParseTimeZone parses a time zone, either an IANA name such as
+// Europe/Rome or a fixed offset from UTC such as +02:00.
+func ParseTimeZone(name string) (*time.Location, error) {
+	if m := fixedZone.FindStringSubmatch(name); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		offset := hours*3600 + minutes*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(name, offset), nil
+	}
+	return time.LoadLocation(name)
 }
 
 // Load reads the content of the config.json file that should be in the same directory
@@ -59,6 +100,45 @@ func Load() (*Config, error) {
 		if c.Targets[i].Perl == "" {
 			c.Targets[i].Perl = c.Perl
 		}
+		if c.Targets[i].TimeZone == "" {
+			c.Targets[i].TimeZone = c.TimeZone
+		}
+	}
+	// Parse the time zones once and for all
+	if c.TimeZone != "" {
+		defaultLocation, err = ParseTimeZone(c.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time_zone: %s", err.Error())
+		}
+	}
+	for i := range c.Targets {
+		t := &c.Targets[i]
+		if t.TimeZone != "" {
+			t.location, err = ParseTimeZone(t.TimeZone)
+			if err != nil {
+				return nil, fmt.Errorf("target %s: invalid time_zone: %s", t.Name, err.Error())
+			}
+		}
+		t.cameraLocations = make(map[string]*time.Location)
+		for camera, zone := range t.CameraTimeZones {
+			t.cameraLocations[camera], err = ParseTimeZone(zone)
+			if err != nil {
+				return nil, fmt.Errorf("target %s: invalid time zone for camera %s: %s", t.Name, camera, err.Error())
+			}
+		}
+	}
+	// Validate the naming templates, so that they can be trusted later on
+	for _, t := range c.Targets {
+		if t.RenameTemplate != "" {
+			if _, err := naming.Parse(t.RenameTemplate, false); err != nil {
+				return nil, fmt.Errorf("target %s: invalid rename_template: %s", t.Name, err.Error())
+			}
+		}
+		if t.FolderTemplate != "" {
+			if _, err := naming.Parse(t.FolderTemplate, true); err != nil {
+				return nil, fmt.Errorf("target %s: invalid folder_template: %s", t.Name, err.Error())
+			}
+		}
 	}
 	return &c, nil
 }
@@ -84,3 +164,53 @@ func (t *Target) GetLocalCachePath() string {
 	exePath := utils.GetExePath()
 	return filepath.Join(exePath, t.Name+"_cache.json.gz")
 }
+
+// GetRemoteThumbnailsDir returns the directory of the thumbnails of the
+// specified size on the filesystem of the target.
+func (t *Target) GetRemoteThumbnailsDir(size int) string {
+	return t.WorkDir + t.Name + "_thumbs" + t.SSHPathSeparator + strconv.Itoa(size)
+}
+
+// GetThumbnailsDir returns the directory of the thumbnails of the
+// specified size on the executable filesystem.
+func (t *Target) GetThumbnailsDir(size int) string {
+	exePath := utils.GetExePath()
+	return filepath.Join(exePath, t.Name+"_thumbs", strconv.Itoa(size))
+}
+
+// GetRenameTemplate returns the template used to rename the photos
+// filtered for the target. A nil target gets the default template.
+func (t *Target) GetRenameTemplate() *naming.Template {
+	if t == nil || t.RenameTemplate == "" {
+		return naming.MustParse(naming.DefaultRename, false)
+	}
+	return naming.MustParse(t.RenameTemplate, false)
+}
+
+// GetFolderTemplate returns the template of the folders where the new
+// photos filtered for the target are put. A nil target gets the default
+// template.
+func (t *Target) GetFolderTemplate() *naming.Template {
+	if t == nil || t.FolderTemplate == "" {
+		return naming.MustParse(naming.DefaultFolder, true)
+	}
+	return naming.MustParse(t.FolderTemplate, true)
+}
+
+// GetLocation returns the time zone of the photos taken by the specified
+// camera (make and model) whose metadata doesn't tell it: the one set for
+// the camera, otherwise the one of the target, otherwise the default time
+// zone of the configuration or of the system. A nil target gets the
+// default time zone.
+func (t *Target) GetLocation(camera string) *time.Location {
+	if t == nil {
+		return defaultLocation
+	}
+	if loc, exists := t.cameraLocations[camera]; exists {
+		return loc
+	}
+	if t.location != nil {
+		return t.location
+	}
+	return defaultLocation
+}
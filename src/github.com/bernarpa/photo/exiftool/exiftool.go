@@ -1,52 +1,191 @@
 package exiftool
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os/exec"
-	"path/filepath"
+	"math"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/bernarpa/photo/utils"
 )
 
-// Output describes part of the exiftool -json output.
+// DefaultTimeout is the maximum time an exiftool request can take
+// before the process that is serving it gets killed.
+const DefaultTimeout = 30 * time.Second
+
+// ErrClosed is returned when a request is sent to a closed Exiftool.
+var ErrClosed = errors.New("exiftool: closed")
+
+// Text is a tag value that exiftool prints as a JSON string
+// or, if it looks like a number, as a JSON number.
+type Text string
+
+// UnmarshalJSON accepts both JSON strings and numbers.
+func (t *Text) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = Text(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*t = Text(n.String())
+	return nil
+}
+
+// Output describes part of the exiftool -json -n output. The dates are
+// written as in Exif, e.g. 2021:07:14 18:30:05, optionally followed
+// by the UTC offset. The other values are written as numbers, e.g. the
+// GPS coordinates in signed decimal degrees and the durations in seconds.
 type Output struct {
-	Timestamp        int64
-	DateTimeOriginal string `json:"DateTimeOriginal"`
-	MediaCreateDate  string `json:"MediaCreateDate"`
-	Make             string `json:"Make"`
-	Model            string `json:"Model"`
+	DateTimeOriginal   string `json:"DateTimeOriginal"`
+	OffsetTimeOriginal string `json:"OffsetTimeOriginal"`
+	CreationDate       string `json:"CreationDate"`
+	MediaCreateDate    string `json:"MediaCreateDate"`
+	SubSecTimeOriginal Text   `json:"SubSecTimeOriginal"`
+	Make               string `json:"Make"`
+	Model              string `json:"Model"`
+	GPSLatitude        Text   `json:"GPSLatitude"`
+	GPSLongitude       Text   `json:"GPSLongitude"`
+	GPSAltitude        Text   `json:"GPSAltitude"`
+	ImageWidth         Text   `json:"ImageWidth"`
+	ImageHeight        Text   `json:"ImageHeight"`
+	Orientation        Text   `json:"Orientation"`
+	LensModel          Text   `json:"LensModel"`
+	ISO                Text   `json:"ISO"`
+	ExposureTime       Text   `json:"ExposureTime"`
+	FNumber            Text   `json:"FNumber"`
+	Duration           Text   `json:"Duration"`
 }
 
-// Exiftool is a wrapper around the exiftool Perl program
+// Exiftool is a wrapper around the exiftool Perl program. It keeps a pool
+// of exiftool processes running in -stay_open mode, so that the Perl
+// interpreter isn't started again for every file. It is safe to use an
+// Exiftool from multiple goroutines.
 type Exiftool struct {
-	Perl string
+	Perl    string
+	Timeout time.Duration
+	size    int
+	started int
+	closed  bool
+	mutex   sync.Mutex
+	// idle are the processes waiting for a request; available is
+	// signaled when a process becomes idle, a slot of the pool is
+	// freed or the Exiftool is closed
+	idle      []*process
+	available *sync.Cond
 }
 
-// Create creates a new Exiftool wrapper instance.
-func Create(perl string) *Exiftool {
-	return &Exiftool{Perl: perl}
+// Create creates a new Exiftool wrapper instance which runs
+// up to size exiftool processes at the same time.
+func Create(perl string, size int) *Exiftool {
+	if size < 1 {
+		size = 1
+	}
+	et := &Exiftool{
+		Perl:    perl,
+		Timeout: DefaultTimeout,
+		size:    size,
+	}
+	et.available = sync.NewCond(&et.mutex)
+	return et
 }
 
-func parseExifTstamp(exifTstamp string) int64 {
-	tm, err := time.Parse("2006:01:02 15:04:05-0700", exifTstamp)
-	if err != nil {
-		tm, err = time.Parse("2006:01:02 15:04:05", exifTstamp)
+// acquire takes an idle process from the pool, starting a new one if
+// the pool isn't full yet, or waits for a busy process to be released.
+func (et *Exiftool) acquire() (*process, error) {
+	et.mutex.Lock()
+	for {
+		if et.closed {
+			et.mutex.Unlock()
+			return nil, ErrClosed
+		}
+		if n := len(et.idle); n > 0 {
+			p := et.idle[n-1]
+			et.idle = et.idle[:n-1]
+			et.mutex.Unlock()
+			return p, nil
+		}
+		if et.started < et.size {
+			break
+		}
+		et.available.Wait()
 	}
+	et.started++
+	et.mutex.Unlock()
+	p, err := startProcess(et.Perl)
 	if err != nil {
-		return 0
+		et.mutex.Lock()
+		et.started--
+		et.available.Signal()
+		et.mutex.Unlock()
+		return nil, err
+	}
+	return p, nil
+}
+
+// release puts a process back into the pool, unless it has died
+// or the Exiftool has been closed in the meantime.
+func (et *Exiftool) release(p *process) {
+	et.mutex.Lock()
+	defer et.mutex.Unlock()
+	if !p.alive() {
+		et.started--
+		et.available.Signal()
+		return
+	}
+	if et.closed {
+		p.close()
+		return
+	}
+	et.idle = append(et.idle, p)
+	et.available.Signal()
+}
+
+// execute sends a request to one of the exiftool processes and returns
+// its output. If the process crashes the request is tried once more
+// with a new process.
+func (et *Exiftool) execute(args ...string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		p, err := et.acquire()
+		if err != nil {
+			return nil, err
+		}
+		out, err := p.execute(args, et.Timeout)
+		et.release(p)
+		if err == nil || err == errTimeout || p.alive() || attempt > 0 {
+			return out, err
+		}
+		log.Printf("exiftool process crashed (%s), restarting it\n", err.Error())
+	}
+}
+
+// Close stops all the exiftool processes. The processes that are
+// serving a request are stopped as soon as they are released, and the
+// requests waiting for a process get ErrClosed.
+func (et *Exiftool) Close() error {
+	et.mutex.Lock()
+	defer et.mutex.Unlock()
+	if et.closed {
+		return nil
+	}
+	et.closed = true
+	for _, p := range et.idle {
+		p.close()
 	}
-	return tm.Unix()
+	et.idle = nil
+	et.available.Broadcast()
+	return nil
 }
 
 // Parse parses the tags for the specified file by using exiftool.
 func (et *Exiftool) Parse(fileName string) (*Output, error) {
-	exePath := utils.GetExePath()
-	exiftoolExe := filepath.Join(exePath, "exiftool", "exiftool")
-	cmd := exec.Command(et.Perl, exiftoolExe, "-json", fileName)
-	out, err := cmd.CombinedOutput()
+	out, err := et.execute("-json", "-n", fileName)
 	if err != nil {
 		return nil, err
 	}
@@ -55,26 +194,101 @@ func (et *Exiftool) Parse(fileName string) (*Output, error) {
 	if err != nil {
 		return nil, err
 	}
-	for i := range outputs {
-		tstamp := parseExifTstamp(outputs[i].DateTimeOriginal)
-		if tstamp == 0 {
-			tstamp = parseExifTstamp(outputs[i].MediaCreateDate)
-		}
-		if err == nil {
-			outputs[i].Timestamp = tstamp
-		}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("exiftool: no output for %s", fileName)
 	}
 	return &outputs[0], nil
 }
 
-// Dump prints the tags for the specified file by using exiftool.
-func (et *Exiftool) Dump(fileName string) {
-	exePath := utils.GetExePath()
-	exiftoolExe := filepath.Join(exePath, "exiftool", "exiftool")
-	cmd := exec.Command(et.Perl, exiftoolExe, fileName)
-	out, err := cmd.CombinedOutput()
+// Tags returns all the tags that exiftool finds in the specified file,
+// formatted as strings.
+func (et *Exiftool) Tags(fileName string) (map[string]string, error) {
+	out, err := et.execute("-json", fileName)
 	if err != nil {
-		log.Printf("Parsing error: %s\n", err.Error())
+		return nil, err
+	}
+	var outputs []map[string]interface{}
+	// Keep numbers as written by exiftool, e.g. 1234567 and not 1.234567e+06
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	decoder.UseNumber()
+	err = decoder.Decode(&outputs)
+	if err != nil {
+		return nil, err
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("exiftool: no output for %s", fileName)
+	}
+	tags := make(map[string]string)
+	for name, value := range outputs[0] {
+		if name != "SourceFile" {
+			tags[name] = fmt.Sprint(value)
+		}
+	}
+	return tags, nil
+}
+
+// shiftedTags are the date and time tags changed by ShiftDates: the Exif
+// dates of photos and the QuickTime dates of videos.
+var shiftedTags = []string{"AllDates", "TrackCreateDate", "TrackModifyDate", "MediaCreateDate", "MediaModifyDate", "Keys:CreationDate"}
+
+// ShiftDates adds the specified offset, which can be negative, to the
+// date and time tags of a file. The file is overwritten.
+func (et *Exiftool) ShiftDates(fileName string, offset time.Duration) error {
+	op := "+="
+	if offset < 0 {
+		op = "-="
+		offset = -offset
+	}
+	seconds := int64(offset / time.Second)
+	// Y:M:D h:m:s, see the exiftool documentation of Image::ExifTool::Shift
+	shift := fmt.Sprintf("0:0:%d %d:%d:%d", seconds/86400, seconds%86400/3600, seconds%3600/60, seconds%60)
+	args := []string{"-overwrite_original"}
+	for _, tag := range shiftedTags {
+		args = append(args, "-"+tag+op+shift)
+	}
+	out, err := et.execute(append(args, fileName)...)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(out), "1 image files updated") {
+		return fmt.Errorf("exiftool: %s not updated: %s", fileName, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// SetLocation writes the GPS position to the tags of a file: the Exif
+// GPS tags of photos and the QuickTime GPS coordinates of videos. The
+// altitude is written only if hasAltitude is true. The file is overwritten.
+func (et *Exiftool) SetLocation(fileName string, latitude, longitude, altitude float64, hasAltitude bool) error {
+	latRef, lonRef, altRef := "N", "E", "0"
+	if latitude < 0 {
+		latRef = "S"
+	}
+	if longitude < 0 {
+		lonRef = "W"
+	}
+	if altitude < 0 {
+		altRef = "1"
+	}
+	coordinates := fmt.Sprintf("%.6f, %.6f", latitude, longitude)
+	args := []string{
+		"-overwrite_original",
+		fmt.Sprintf("-GPSLatitude=%.6f", math.Abs(latitude)),
+		"-GPSLatitudeRef=" + latRef,
+		fmt.Sprintf("-GPSLongitude=%.6f", math.Abs(longitude)),
+		"-GPSLongitudeRef=" + lonRef,
+	}
+	if hasAltitude {
+		args = append(args, fmt.Sprintf("-GPSAltitude=%.1f", math.Abs(altitude)), "-GPSAltitudeRef#="+altRef)
+		coordinates += fmt.Sprintf(", %.1f", altitude)
+	}
+	args = append(args, "-Keys:GPSCoordinates="+coordinates, "-UserData:GPSCoordinates="+coordinates)
+	out, err := et.execute(append(args, fileName)...)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(out), "1 image files updated") {
+		return fmt.Errorf("exiftool: %s not updated: %s", fileName, strings.TrimSpace(string(out)))
 	}
-	fmt.Printf("%s\n", out)
+	return nil
 }
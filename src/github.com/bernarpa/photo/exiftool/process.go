@@ -0,0 +1,167 @@
+package exiftool
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bernarpa/photo/utils"
+)
+
+var errTimeout = errors.New("exiftool: request timed out")
+
+// lockedBuffer is a bytes.Buffer that can be written by the goroutine
+// which copies the stderr of a process while it's read by another one.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+// Take returns the content of the buffer and empties it.
+func (b *lockedBuffer) Take() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s := b.buffer.String()
+	b.buffer.Reset()
+	return s
+}
+
+// process is an exiftool instance running in -stay_open mode, which
+// reads the arguments from stdin and writes a {readyNNN} line to
+// stdout at the end of each request.
+type process struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr lockedBuffer
+	seq    int
+	dead   bool
+	exited chan struct{}
+}
+
+func startProcess(perl string) (*process, error) {
+	exePath := utils.GetExePath()
+	exiftoolExe := filepath.Join(exePath, "exiftool", "exiftool")
+	p := &process{exited: make(chan struct{})}
+	p.cmd = exec.Command(perl, exiftoolExe, "-stay_open", "True", "-@", "-", "-common_args", "-charset", "filename=utf8")
+	p.cmd.Stderr = &p.stderr
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = p.cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	p.stdin = stdin
+	p.stdout = bufio.NewReader(stdout)
+	go func() {
+		p.cmd.Wait()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+func (p *process) alive() bool {
+	return !p.dead
+}
+
+// execute sends the arguments to exiftool, one per line, and waits for
+// the output of the request, killing the process if it takes too long.
+func (p *process) execute(args []string, timeout time.Duration) ([]byte, error) {
+	p.seq++
+	ready := fmt.Sprintf("{ready%d}", p.seq)
+	p.stderr.Take()
+	var request strings.Builder
+	for _, arg := range args {
+		request.WriteString(arg)
+		request.WriteString("\n")
+	}
+	request.WriteString(fmt.Sprintf("-execute%d\n", p.seq))
+	_, err := io.WriteString(p.stdin, request.String())
+	if err != nil {
+		p.kill()
+		return nil, err
+	}
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := p.readUntil(ready)
+		done <- result{out, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			p.kill()
+			return nil, r.err
+		}
+		if len(bytes.TrimSpace(r.out)) == 0 {
+			if msg := strings.TrimSpace(p.stderr.Take()); msg != "" {
+				return nil, errors.New("exiftool: " + msg)
+			}
+		}
+		return r.out, nil
+	case <-time.After(timeout):
+		p.kill()
+		<-done
+		return nil, errTimeout
+	}
+}
+
+// readUntil reads the stdout of the process up to the ready line.
+func (p *process) readUntil(ready string) ([]byte, error) {
+	var out bytes.Buffer
+	for {
+		line, err := p.stdout.ReadString('\n')
+		if strings.TrimRight(line, "\r\n") == ready {
+			return out.Bytes(), nil
+		}
+		out.WriteString(line)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+// kill terminates the process immediately.
+func (p *process) kill() {
+	p.dead = true
+	p.cmd.Process.Kill()
+	<-p.exited
+}
+
+// close asks exiftool to terminate and kills it if it doesn't.
+func (p *process) close() {
+	p.dead = true
+	io.WriteString(p.stdin, "-stay_open\nFalse\n")
+	p.stdin.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+}
@@ -0,0 +1,212 @@
+package fileops
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bernarpa/photo/utils"
+)
+
+// Operations recorded in an Action.
+const (
+	OpMkdir   = "mkdir"
+	OpMove    = "move"
+	OpRename  = "rename"
+	OpConvert = "convert"
+	OpRemove  = "remove"
+	OpCreate  = "create"
+	OpIgnore  = "ignore"
+	OpLink    = "link"
+	OpCopy    = "copy"
+	OpModify  = "modify"
+)
+
+// Action is a filesystem change, performed or planned.
+type Action struct {
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"`
+}
+
+// FileOps performs the filesystem changes of the operations that
+// reorganize photos, so that they can be simulated or recorded.
+type FileOps interface {
+	MkdirAll(dir string) error
+	Rename(oldPath, newPath string) error
+	HeicToJPEG(heicFile, jpegFile string) error
+	Remove(path string) error
+	Link(oldPath, newPath string) error
+	Copy(oldPath, newPath string) error
+	Modify(path string, change func(path string) error) error
+	Exists(path string) bool
+}
+
+// renameOp tells apart a rename within the same directory from a move.
+func renameOp(oldPath, newPath string) string {
+	if filepath.Dir(oldPath) == filepath.Dir(newPath) {
+		return OpRename
+	}
+	return OpMove
+}
+
+// Disk performs the changes on the filesystem.
+type Disk struct{}
+
+// MkdirAll creates a directory and its parents.
+func (Disk) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+// Rename renames or moves a file.
+func (Disk) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+// HeicToJPEG converts an HEIC image to a JPEG image.
+func (Disk) HeicToJPEG(heicFile, jpegFile string) error {
+	return utils.HeicToJPEG(heicFile, jpegFile)
+}
+
+// Remove deletes a file.
+func (Disk) Remove(path string) error {
+	return os.Remove(path)
+}
+
+// Link creates newPath as a hard link to oldPath.
+func (Disk) Link(oldPath, newPath string) error {
+	return os.Link(oldPath, newPath)
+}
+
+// Copy copies a file to newPath, which must not exist.
+func (Disk) Copy(oldPath, newPath string) error {
+	return utils.CopyFile(oldPath, newPath)
+}
+
+// Modify changes the content of a file in place by calling change.
+func (Disk) Modify(path string, change func(path string) error) error {
+	return change(path)
+}
+
+// Exists checks whether a file or directory exists.
+func (Disk) Exists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+// DryRun records the changes instead of performing them. It keeps track
+// of the files that would be created or removed, so that Exists answers
+// as if the planned changes had been performed.
+type DryRun struct {
+	Actions []Action
+	created map[string]bool
+	removed map[string]bool
+}
+
+// NewDryRun creates an empty DryRun.
+func NewDryRun() *DryRun {
+	return &DryRun{created: make(map[string]bool), removed: make(map[string]bool)}
+}
+
+// Add records an action.
+func (d *DryRun) Add(op, path, target string) {
+	d.Actions = append(d.Actions, Action{Op: op, Path: path, Target: target})
+}
+
+func (d *DryRun) create(path string) {
+	d.created[path] = true
+	delete(d.removed, path)
+}
+
+func (d *DryRun) remove(path string) {
+	d.removed[path] = true
+	delete(d.created, path)
+}
+
+// MkdirAll plans the creation of a directory, unless it already exists.
+func (d *DryRun) MkdirAll(dir string) error {
+	if !d.Exists(dir) {
+		d.Add(OpMkdir, dir, "")
+		d.create(dir)
+	}
+	return nil
+}
+
+// Rename plans a rename or a move.
+func (d *DryRun) Rename(oldPath, newPath string) error {
+	d.Add(renameOp(oldPath, newPath), oldPath, newPath)
+	d.remove(oldPath)
+	d.create(newPath)
+	return nil
+}
+
+// HeicToJPEG plans an HEIC to JPEG conversion.
+func (d *DryRun) HeicToJPEG(heicFile, jpegFile string) error {
+	d.Add(OpConvert, heicFile, jpegFile)
+	d.create(jpegFile)
+	return nil
+}
+
+// Remove plans the deletion of a file.
+func (d *DryRun) Remove(path string) error {
+	d.Add(OpRemove, path, "")
+	d.remove(path)
+	return nil
+}
+
+// Link plans the creation of a hard link.
+func (d *DryRun) Link(oldPath, newPath string) error {
+	d.Add(OpLink, oldPath, newPath)
+	d.create(newPath)
+	return nil
+}
+
+// Copy plans the copy of a file.
+func (d *DryRun) Copy(oldPath, newPath string) error {
+	d.Add(OpCopy, oldPath, newPath)
+	d.create(newPath)
+	return nil
+}
+
+// Modify plans an in-place change of a file, without calling change.
+func (d *DryRun) Modify(path string, change func(path string) error) error {
+	d.Add(OpModify, path, "")
+	return nil
+}
+
+// Exists checks whether a file or directory would exist after
+// the planned changes.
+func (d *DryRun) Exists(path string) bool {
+	if d.created[path] {
+		return true
+	}
+	if d.removed[path] {
+		return false
+	}
+	return Disk{}.Exists(path)
+}
+
+// Print prints the planned actions in the specified format (text or json).
+func (d *DryRun) Print(format string) error {
+	switch format {
+	case "json":
+		out, err := json.MarshalIndent(d.Actions, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	case "text":
+		for _, action := range d.Actions {
+			if action.Target != "" {
+				fmt.Printf("%-8s %s -> %s\n", action.Op, action.Path, action.Target)
+			} else {
+				fmt.Printf("%-8s %s\n", action.Op, action.Path)
+			}
+		}
+		fmt.Printf("%d actions planned, nothing has been changed\n", len(d.Actions))
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+	return nil
+}
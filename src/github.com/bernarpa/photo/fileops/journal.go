@@ -0,0 +1,384 @@
+package fileops
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bernarpa/photo/utils"
+)
+
+// JournalPrefix and JournalExt make up the file name of the undo journals,
+// e.g. photojournal_2021-01-02_15-04-05.jsonl.
+const (
+	JournalPrefix = "photojournal_"
+	JournalExt    = ".jsonl"
+	trashExt      = ".trash"
+	undoneExt     = ".undone"
+)
+
+// Journal performs the changes on the filesystem and records them in a
+// journal file, one JSON Action per line, so that they can be undone.
+// Removed files are moved to a trash directory next to the journal
+// instead of being deleted. The journal file is created when the first
+// change is recorded, so that nothing is left behind by an operation
+// that doesn't change anything, even if it exits without closing it.
+type Journal struct {
+	Path    string
+	file    *os.File
+	count   int
+	trashed int
+}
+
+// CreateJournal creates a new, empty journal in the specified directory.
+func CreateJournal(dir string) (*Journal, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	nowStr := time.Now().Format("2006-01-02_15-04-05")
+	for i := 0; ; i++ {
+		name := JournalPrefix + nowStr
+		if i > 0 {
+			name += fmt.Sprintf("_%d", i)
+		}
+		path := filepath.Join(dir, name+JournalExt)
+		if _, err := os.Lstat(path); err == nil {
+			continue
+		}
+		// Undone journals keep their name, with a suffix
+		if _, err := os.Lstat(path + undoneExt); err == nil {
+			continue
+		}
+		if _, err := os.Lstat(trashDir(path)); err == nil {
+			continue
+		}
+		return &Journal{Path: path}, nil
+	}
+}
+
+// LatestJournal returns the most recent journal in the specified directory.
+func LatestJournal(dir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, JournalPrefix+"*"+JournalExt))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no journal found in %s", dir)
+	}
+	sort.Strings(matches)
+	return matches[len(matches)-1], nil
+}
+
+// IsTrash checks whether a path is the trash directory of a journal.
+func IsTrash(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, JournalPrefix) && strings.HasSuffix(name, trashExt)
+}
+
+func trashDir(journalPath string) string {
+	return strings.TrimSuffix(journalPath, JournalExt) + trashExt
+}
+
+// record appends an action to the journal, with absolute paths so that
+// it can be undone from any working directory.
+func (j *Journal) record(op, path, target string) error {
+	if j.file == nil {
+		f, err := os.OpenFile(j.Path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		j.file = f
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if target != "" {
+		target, err = filepath.Abs(target)
+		if err != nil {
+			return err
+		}
+	}
+	line, err := json.Marshal(Action{Op: op, Path: path, Target: target})
+	if err != nil {
+		return err
+	}
+	_, err = j.file.Write(append(line, '\n'))
+	if err == nil {
+		j.count++
+	}
+	return err
+}
+
+// Close closes the journal file. The trash directory is deleted if it's
+// empty, e.g. because the change of a modified file failed.
+func (j *Journal) Close() error {
+	RemoveTrash(j.Path)
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// Empty reports whether nothing has been recorded in the journal.
+func (j *Journal) Empty() bool {
+	return j.count == 0
+}
+
+// MkdirAll creates a directory and its parents, recording
+// each directory that didn't exist.
+func (j *Journal) MkdirAll(dir string) error {
+	var missing []string
+	for d := filepath.Clean(dir); !(Disk{}).Exists(d); d = filepath.Dir(d) {
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		err := os.Mkdir(missing[i], 0755)
+		if err != nil {
+			return err
+		}
+		j.record(OpMkdir, missing[i], "")
+	}
+	return nil
+}
+
+// Rename renames or moves a file and records it.
+func (j *Journal) Rename(oldPath, newPath string) error {
+	err := os.Rename(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	return j.record(renameOp(oldPath, newPath), oldPath, newPath)
+}
+
+// HeicToJPEG converts an HEIC image to a JPEG image and records it.
+func (j *Journal) HeicToJPEG(heicFile, jpegFile string) error {
+	err := utils.HeicToJPEG(heicFile, jpegFile)
+	if err != nil {
+		return err
+	}
+	return j.record(OpConvert, heicFile, jpegFile)
+}
+
+// trashPath returns a new path in the trash directory of the journal
+// for the specified file.
+func (j *Journal) trashPath(path string) (string, error) {
+	trash := trashDir(j.Path)
+	err := os.MkdirAll(trash, 0755)
+	if err != nil {
+		return "", err
+	}
+	j.trashed++
+	return filepath.Join(trash, fmt.Sprintf("%d_%s", j.trashed, filepath.Base(path))), nil
+}
+
+// Remove moves a file to the trash directory of the journal and records it.
+func (j *Journal) Remove(path string) error {
+	trashPath, err := j.trashPath(path)
+	if err != nil {
+		return err
+	}
+	// The trash could be on another filesystem
+	err = moveFile(path, trashPath)
+	if err != nil {
+		return err
+	}
+	return j.record(OpRemove, path, trashPath)
+}
+
+// rename is os.Rename, replaced by the tests to simulate a move
+// between different filesystems.
+var rename = os.Rename
+
+// moveFile moves a file like os.Rename, replacing newPath if it exists.
+// If the file can't be renamed, e.g. because newPath is on another
+// filesystem, it's copied next to newPath and the original is removed.
+func moveFile(oldPath, newPath string) error {
+	if rename(oldPath, newPath) == nil {
+		return nil
+	}
+	tmpPath := filepath.Join(filepath.Dir(newPath), "."+filepath.Base(newPath)+".tmp")
+	err := utils.CopyFile(oldPath, tmpPath)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, newPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Remove(oldPath)
+}
+
+// Link creates newPath as a hard link to oldPath and records it.
+func (j *Journal) Link(oldPath, newPath string) error {
+	err := os.Link(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	return j.record(OpLink, oldPath, newPath)
+}
+
+// Copy copies a file to newPath, which must not exist, and records it.
+func (j *Journal) Copy(oldPath, newPath string) error {
+	err := utils.CopyFile(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	return j.record(OpCopy, oldPath, newPath)
+}
+
+// Modify changes the content of a file in place by calling change and
+// records it. The original file is copied to the trash directory first,
+// so that it can be restored.
+func (j *Journal) Modify(path string, change func(path string) error) error {
+	backup, err := j.trashPath(path)
+	if err != nil {
+		return err
+	}
+	err = utils.CopyFile(path, backup)
+	if err != nil {
+		return err
+	}
+	err = change(path)
+	if err != nil {
+		os.Remove(backup)
+		return err
+	}
+	return j.record(OpModify, path, backup)
+}
+
+// Exists checks whether a file or directory exists.
+func (j *Journal) Exists(path string) bool {
+	return Disk{}.Exists(path)
+}
+
+// LoadJournal reads the actions recorded in a journal file.
+func LoadJournal(path string) ([]Action, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var actions []Action
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var action Action
+		err = json.Unmarshal([]byte(line), &action)
+		if err != nil {
+			return nil, fmt.Errorf("invalid journal entry %q: %s", line, err.Error())
+		}
+		actions = append(actions, action)
+	}
+	return actions, scanner.Err()
+}
+
+// Undo reverts an action recorded in a journal. If the action no longer
+// applies nothing is changed and the reason is returned as an error.
+func Undo(action Action) error {
+	disk := Disk{}
+	switch action.Op {
+	case OpRename, OpMove, OpRemove:
+		if !disk.Exists(action.Target) {
+			return fmt.Errorf("%s no longer exists", action.Target)
+		}
+		if disk.Exists(action.Path) {
+			return fmt.Errorf("%s already exists", action.Path)
+		}
+		return moveFile(action.Target, action.Path)
+	case OpConvert:
+		if !disk.Exists(action.Path) {
+			return fmt.Errorf("the original %s is missing, keeping %s", action.Path, action.Target)
+		}
+		if !disk.Exists(action.Target) {
+			return fmt.Errorf("%s no longer exists", action.Target)
+		}
+		return os.Remove(action.Target)
+	case OpLink, OpCopy:
+		if !disk.Exists(action.Target) {
+			return fmt.Errorf("%s no longer exists", action.Target)
+		}
+		return os.Remove(action.Target)
+	case OpModify:
+		if !disk.Exists(action.Target) {
+			return fmt.Errorf("the original %s is missing from the trash", action.Target)
+		}
+		if !disk.Exists(action.Path) {
+			return fmt.Errorf("%s no longer exists", action.Path)
+		}
+		return moveFile(action.Target, action.Path)
+	case OpMkdir:
+		if !disk.Exists(action.Path) {
+			return fmt.Errorf("%s no longer exists", action.Path)
+		}
+		return os.Remove(action.Path)
+	default:
+		return fmt.Errorf("unsupported operation: %s", action.Op)
+	}
+}
+
+// MarkUndone renames a journal that has been replayed by undo, so that
+// it isn't picked up again.
+func MarkUndone(journalPath string) error {
+	return os.Rename(journalPath, journalPath+undoneExt)
+}
+
+// RemoveTrash deletes the trash directory of a journal, if it's empty.
+func RemoveTrash(journalPath string) {
+	os.Remove(trashDir(journalPath))
+}
+
+// Trashes returns the trash directories of the journals in the specified
+// directory, including the journals that have been undone.
+func Trashes(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, JournalPrefix+"*"+trashExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// TrashOf returns the trash directory of a journal.
+func TrashOf(journalPath string) string {
+	return trashDir(strings.TrimSuffix(journalPath, undoneExt))
+}
+
+// PurgeTrash deletes a trash directory with its content, so that the
+// space used by the removed and modified files is freed. Afterwards the
+// removals and modifications recorded in the journal can't be undone.
+// It returns the number of files and bytes deleted.
+func PurgeTrash(trash string) (int, int64, error) {
+	files := 0
+	var size int64
+	err := filepath.Walk(trash, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return files, size, os.RemoveAll(trash)
+}
@@ -0,0 +1,114 @@
+package fileops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkFile(t *testing.T, path, want string) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+	} else if string(content) != want {
+		t.Errorf("%s contains %q, want %q", path, content, want)
+	}
+}
+
+func TestJournalUndo(t *testing.T) {
+	tests := []struct {
+		name      string
+		crossDevs bool
+	}{
+		{"same filesystem", false},
+		{"across filesystems", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.crossDevs {
+				rename = func(oldPath, newPath string) error {
+					return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.EXDEV}
+				}
+				defer func() { rename = os.Rename }()
+			}
+			// The journal and its trash aren't in the directory of the photos
+			journalDir := t.TempDir()
+			photoDir := t.TempDir()
+			moved := filepath.Join(photoDir, "moved.jpg")
+			removed := filepath.Join(photoDir, "removed.jpg")
+			modified := filepath.Join(photoDir, "modified.jpg")
+			writeFile(t, moved, "moved")
+			writeFile(t, removed, "removed")
+			writeFile(t, modified, "original")
+
+			journal, err := CreateJournal(journalDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			newDir := filepath.Join(photoDir, "2021", "06")
+			if err := journal.MkdirAll(newDir); err != nil {
+				t.Fatal(err)
+			}
+			if err := journal.Rename(moved, filepath.Join(newDir, "moved.jpg")); err != nil {
+				t.Fatal(err)
+			}
+			if err := journal.Remove(removed); err != nil {
+				t.Fatal(err)
+			}
+			err = journal.Modify(modified, func(path string) error {
+				return ioutil.WriteFile(path, []byte("changed"), 0644)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := journal.Close(); err != nil {
+				t.Fatal(err)
+			}
+			checkFile(t, modified, "changed")
+			if (Disk{}).Exists(removed) {
+				t.Fatalf("%s hasn't been removed", removed)
+			}
+
+			actions, err := LoadJournal(journal.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(actions) != 5 {
+				t.Fatalf("%d actions recorded, want 5: %+v", len(actions), actions)
+			}
+			for i := len(actions) - 1; i >= 0; i-- {
+				if err := Undo(actions[i]); err != nil {
+					t.Errorf("Undo(%+v): %v", actions[i], err)
+				}
+			}
+			checkFile(t, moved, "moved")
+			checkFile(t, removed, "removed")
+			checkFile(t, modified, "original")
+			if (Disk{}).Exists(filepath.Join(photoDir, "2021")) {
+				t.Error("the created directories haven't been removed")
+			}
+			files, _, err := PurgeTrash(TrashOf(journal.Path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if files != 0 {
+				t.Errorf("%d files left in the trash", files)
+			}
+			entries, err := ioutil.ReadDir(photoDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 3 {
+				t.Errorf("%d files in %s, want 3", len(entries), photoDir)
+			}
+		})
+	}
+}
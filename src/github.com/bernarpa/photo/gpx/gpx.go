@@ -0,0 +1,132 @@
+package gpx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Point is a position of a track.
+type Point struct {
+	Time         time.Time
+	Latitude     float64
+	Longitude    float64
+	Elevation    float64
+	HasElevation bool
+}
+
+// Track is a list of positions sorted by time, read from one or more GPX
+// files.
+type Track struct {
+	Points []Point
+}
+
+// gpxFile describes the part of a GPX file that is read: the points of
+// its tracks.
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []struct {
+				Latitude  float64  `xml:"lat,attr"`
+				Longitude float64  `xml:"lon,attr"`
+				Elevation *float64 `xml:"ele"`
+				Time      string   `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// Load reads the track points of the specified GPX files. The points
+// without time are skipped, since they can't be matched with photos.
+func Load(paths ...string) (*Track, error) {
+	track := &Track{}
+	for _, path := range paths {
+		err := track.add(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+	}
+	sort.SliceStable(track.Points, func(a, b int) bool {
+		return track.Points[a].Time.Before(track.Points[b].Time)
+	})
+	return track, nil
+}
+
+func (track *Track) add(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var file gpxFile
+	err = xml.NewDecoder(f).Decode(&file)
+	if err != nil {
+		return err
+	}
+	for _, trk := range file.Tracks {
+		for _, seg := range trk.Segments {
+			for _, pt := range seg.Points {
+				t, err := time.Parse(time.RFC3339Nano, pt.Time)
+				if err != nil {
+					continue
+				}
+				point := Point{Time: t.UTC(), Latitude: pt.Latitude, Longitude: pt.Longitude}
+				if pt.Elevation != nil {
+					point.Elevation, point.HasElevation = *pt.Elevation, true
+				}
+				track.Points = append(track.Points, point)
+			}
+		}
+	}
+	return nil
+}
+
+// Position returns the position at the specified time, interpolated
+// between the two closest points of the track. There is no position if
+// these points are more than maxGap apart, e.g. because the recording
+// was paused, or if the time is more than maxGap before the start or
+// after the end of the track.
+func (track *Track) Position(t time.Time, maxGap time.Duration) (Point, bool) {
+	points := track.Points
+	if len(points) == 0 {
+		return Point{}, false
+	}
+	// Index of the first point after t
+	i := sort.Search(len(points), func(i int) bool {
+		return points[i].Time.After(t)
+	})
+	switch {
+	case i == 0:
+		if points[0].Time.Sub(t) > maxGap {
+			return Point{}, false
+		}
+		return points[0], true
+	case i == len(points):
+		last := points[len(points)-1]
+		if t.Sub(last.Time) > maxGap {
+			return Point{}, false
+		}
+		return last, true
+	}
+	before, after := points[i-1], points[i]
+	if before.Time.Equal(t) {
+		return before, true
+	}
+	gap := after.Time.Sub(before.Time)
+	if gap > maxGap {
+		return Point{}, false
+	}
+	ratio := float64(t.Sub(before.Time)) / float64(gap)
+	point := Point{
+		Time:         t,
+		Latitude:     before.Latitude + (after.Latitude-before.Latitude)*ratio,
+		Longitude:    before.Longitude + (after.Longitude-before.Longitude)*ratio,
+		HasElevation: before.HasElevation && after.HasElevation,
+	}
+	if point.HasElevation {
+		point.Elevation = before.Elevation + (after.Elevation-before.Elevation)*ratio
+	}
+	return point, true
+}
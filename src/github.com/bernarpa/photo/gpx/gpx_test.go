@@ -0,0 +1,126 @@
+package gpx
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const morning = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk>
+    <trkseg>
+      <trkpt lat="45.0" lon="9.0"><ele>100</ele><time>2021-06-05T08:00:00Z</time></trkpt>
+      <trkpt lat="45.1" lon="9.2"><ele>200</ele><time>2021-06-05T10:00:00+02:00</time></trkpt>
+      <trkpt lat="46.0" lon="10.0"><ele>300</ele></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+const afternoon = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk>
+    <trkseg>
+      <trkpt lat="45.5" lon="9.5"><time>2021-06-05T14:00:00.500Z</time></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="45.6" lon="9.6"><time>2021-06-05T14:01:00Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+func at(clock string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, "2021-06-05T"+clock+"Z")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func writeGPX(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	// The files aren't in chronological order
+	track, err := Load(writeGPX(t, dir, "afternoon.gpx", afternoon), writeGPX(t, dir, "morning.gpx", morning))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Point{
+		{Time: at("08:00:00"), Latitude: 45.0, Longitude: 9.0, Elevation: 100, HasElevation: true},
+		{Time: at("08:00:00"), Latitude: 45.1, Longitude: 9.2, Elevation: 200, HasElevation: true},
+		{Time: at("14:00:00.5"), Latitude: 45.5, Longitude: 9.5},
+		{Time: at("14:01:00"), Latitude: 45.6, Longitude: 9.6},
+	}
+	if len(track.Points) != len(want) {
+		t.Fatalf("Load returned %d points, want %d: %+v", len(track.Points), len(want), track.Points)
+	}
+	for i, point := range track.Points {
+		if point != want[i] {
+			t.Errorf("point %d = %+v, want %+v", i, point, want[i])
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Load(filepath.Join(dir, "missing.gpx")); err == nil {
+		t.Error("Load of a missing file succeeded")
+	}
+	if _, err := Load(writeGPX(t, dir, "broken.gpx", "<gpx><trk>")); err == nil {
+		t.Error("Load of a truncated file succeeded")
+	}
+}
+
+func TestPosition(t *testing.T) {
+	track := &Track{Points: []Point{
+		{Time: at("10:00:00"), Latitude: 45, Longitude: 9, Elevation: 100, HasElevation: true},
+		{Time: at("10:10:00"), Latitude: 46, Longitude: 10, Elevation: 200, HasElevation: true},
+		{Time: at("10:20:00"), Latitude: 46, Longitude: 11},
+		{Time: at("10:20:00"), Latitude: 47, Longitude: 12},
+		// The recording was paused
+		{Time: at("12:00:00"), Latitude: 50, Longitude: 15},
+	}}
+	maxGap := 15 * time.Minute
+	tests := []struct {
+		name  string
+		time  time.Time
+		want  Point
+		found bool
+	}{
+		{"before the start", at("09:50:00"), track.Points[0], true},
+		{"too early", at("09:44:59"), Point{}, false},
+		{"first point", at("10:00:00"), Point{Time: at("10:00:00"), Latitude: 45, Longitude: 9, Elevation: 100, HasElevation: true}, true},
+		{"interpolated", at("10:02:30"), Point{Time: at("10:02:30"), Latitude: 45.25, Longitude: 9.25, Elevation: 125, HasElevation: true}, true},
+		{"without elevation", at("10:15:00"), Point{Time: at("10:15:00"), Latitude: 46, Longitude: 10.5}, true},
+		{"same time", at("10:20:00"), Point{Time: at("10:20:00"), Latitude: 47, Longitude: 12}, true},
+		{"gap", at("11:00:00"), Point{}, false},
+		{"after the end", at("12:15:00"), track.Points[4], true},
+		{"too late", at("12:15:01"), Point{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			point, found := track.Position(test.time, maxGap)
+			if found != test.found {
+				t.Fatalf("Position found = %v, want %v", found, test.found)
+			}
+			if !point.Time.Equal(test.want.Time) || point.HasElevation != test.want.HasElevation ||
+				math.Abs(point.Latitude-test.want.Latitude) > 1e-9 ||
+				math.Abs(point.Longitude-test.want.Longitude) > 1e-9 ||
+				math.Abs(point.Elevation-test.want.Elevation) > 1e-9 {
+				t.Errorf("Position = %+v, want %+v", point, test.want)
+			}
+		})
+	}
+	if _, found := (&Track{}).Position(at("10:00:00"), maxGap); found {
+		t.Error("Position found a point in an empty track")
+	}
+}
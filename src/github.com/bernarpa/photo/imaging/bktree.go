@@ -0,0 +1,65 @@
+package imaging
+
+// BKTree is a Burkhard-Keller tree, which indexes hashes by their Hamming
+// distance so that the hashes close to a given one can be found without
+// comparing it with all of them.
+type BKTree struct {
+	root *bkNode
+}
+
+type bkNode struct {
+	hash     uint64
+	ids      []int
+	children map[int]*bkNode
+}
+
+// Add adds a hash to the tree, identified by id.
+func (t *BKTree) Add(hash uint64, id int) {
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, ids: []int{id}}
+		return
+	}
+	node := t.root
+	for {
+		d := Distance(node.hash, hash)
+		if d == 0 {
+			node.ids = append(node.ids, id)
+			return
+		}
+		child, exists := node.children[d]
+		if !exists {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[d] = &bkNode{hash: hash, ids: []int{id}}
+			return
+		}
+		node = child
+	}
+}
+
+// Search returns the ids of the hashes whose distance from hash is
+// less than or equal to maxDistance.
+func (t *BKTree) Search(hash uint64, maxDistance int) []int {
+	var found []int
+	if t.root == nil {
+		return found
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := Distance(node.hash, hash)
+		if d <= maxDistance {
+			found = append(found, node.ids...)
+		}
+		// By the triangle inequality only the children whose distance
+		// from the node is within d ± maxDistance can contain matches
+		for childDistance, child := range node.children {
+			if childDistance >= d-maxDistance && childDistance <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,122 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	// Image decoders used by image.Decode
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// hashWidth and hashHeight are the size of the grayscale thumbnail used by
+// DHash: each row has hashWidth-1 gradients, for a total of 64 bits.
+const (
+	hashWidth  = 9
+	hashHeight = 8
+)
+
+// Supported checks whether an image can be decoded by this package.
+func Supported(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".jpg" ||
+		ext == ".jpeg" ||
+		ext == ".png" ||
+		ext == ".gif"
+}
+
+// Decode reads and decodes an image file.
+func Decode(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// luma returns the brightness of a pixel, in the 0-65535 range.
+func luma(img image.Image, x, y int) uint32 {
+	if ycc, ok := img.(*image.YCbCr); ok {
+		// Fast path for JPEG images
+		return uint32(ycc.Y[ycc.YOffset(x, y)]) * 0x101
+	}
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (19595*r + 38470*g + 7471*b + 1<<15) >> 16
+}
+
+// grayThumbnail scales an image down to a w x h grayscale thumbnail,
+// averaging the pixels that fall into each cell.
+func grayThumbnail(img image.Image, w, h int) []uint32 {
+	bounds := img.Bounds()
+	thumb := make([]uint32, w*h)
+	for ty := 0; ty < h; ty++ {
+		y0 := bounds.Min.Y + ty*bounds.Dy()/h
+		y1 := bounds.Min.Y + (ty+1)*bounds.Dy()/h
+		for tx := 0; tx < w; tx++ {
+			x0 := bounds.Min.X + tx*bounds.Dx()/w
+			x1 := bounds.Min.X + (tx+1)*bounds.Dx()/w
+			var sum, count uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sum += uint64(luma(img, x, y))
+					count++
+				}
+			}
+			if count > 0 {
+				thumb[ty*w+tx] = uint32(sum / count)
+			}
+		}
+	}
+	return thumb
+}
+
+// DHash computes the difference hash of an image: every bit tells whether
+// a cell of a 9x8 grayscale thumbnail is brighter than the following one.
+// Resized or re-encoded copies of an image have the same or a very close
+// hash.
+func DHash(img image.Image) uint64 {
+	thumb := grayThumbnail(img, hashWidth, hashHeight)
+	var hash uint64
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth-1; x++ {
+			hash <<= 1
+			if thumb[y*hashWidth+x] > thumb[y*hashWidth+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// FileDHash computes the difference hash of an image file.
+func FileDHash(path string) (uint64, error) {
+	img, err := Decode(path)
+	if err != nil {
+		return 0, err
+	}
+	return DHash(img), nil
+}
+
+// Distance returns the Hamming distance between two hashes, i.e. the
+// number of different bits.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash returns the hexadecimal representation of a hash.
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParseHash parses a hash formatted by FormatHash.
+func ParseHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
@@ -0,0 +1,130 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+)
+
+// thumbnailQuality is the JPEG quality of the thumbnails.
+const thumbnailQuality = 80
+
+// Resize scales an image down so that it fits in a size x size square,
+// keeping its aspect ratio. Each pixel of the result is the average of
+// the pixels of the original that it covers. Smaller images are only
+// copied.
+func Resize(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, h*size/w
+		} else {
+			tw, th = w*size/h, size
+		}
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	if tw == w && th == h {
+		draw.Draw(thumb, thumb.Bounds(), img, bounds.Min, draw.Src)
+		return thumb
+	}
+	for ty := 0; ty < th; ty++ {
+		y0 := bounds.Min.Y + ty*h/th
+		y1 := bounds.Min.Y + (ty+1)*h/th
+		for tx := 0; tx < tw; tx++ {
+			x0 := bounds.Min.X + tx*w/tw
+			x1 := bounds.Min.X + (tx+1)*w/tw
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					pr, pg, pb, pa := img.At(x, y).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			i := thumb.PixOffset(tx, ty)
+			thumb.Pix[i] = uint8(r / n >> 8)
+			thumb.Pix[i+1] = uint8(g / n >> 8)
+			thumb.Pix[i+2] = uint8(b / n >> 8)
+			thumb.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return thumb
+}
+
+// Orient rotates and flips an image according to its Exif orientation
+// (1 to 8), so that it's displayed upright.
+func Orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	// Orientations 5 to 8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Flip horizontally
+				dx, dy = w-1-x, y
+			case 3: // Rotate by 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // Flip vertically
+				dx, dy = x, h-1-y
+			case 5: // Flip along the top-left diagonal
+				dx, dy = y, x
+			case 6: // Rotate by 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // Flip along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotate by 90° counterclockwise
+				dx, dy = y, w-1-x
+			}
+			copy(out.Pix[out.PixOffset(dx, dy):out.PixOffset(dx, dy)+4], img.Pix[img.PixOffset(x, y):img.PixOffset(x, y)+4])
+		}
+	}
+	return out
+}
+
+// WriteThumbnail writes a JPEG thumbnail of an image file, which fits in
+// a size x size square and is displayed upright according to the Exif
+// orientation. The thumbnail is written to a temporary file first, so
+// that a partial thumbnail is never left behind.
+func WriteThumbnail(path, thumbPath string, size, orientation int) error {
+	img, err := Decode(path)
+	if err != nil {
+		return err
+	}
+	thumb := Orient(Resize(img, size), orientation)
+	err = os.MkdirAll(filepath.Dir(thumbPath), 0755)
+	if err != nil {
+		return err
+	}
+	tmpPath := thumbPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = jpeg.Encode(f, thumb, &jpeg.Options{Quality: thumbnailQuality})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, thumbPath)
+}
@@ -0,0 +1,421 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned for files that aren't ISO base media files.
+var ErrUnsupported = errors.New("mp4: unsupported file format")
+
+// epochOffset is the number of seconds between 1904-01-01, the epoch of
+// the QuickTime timestamps, and 1970-01-01.
+const epochOffset = 2082844800
+
+// maxValueSize limits the size of the metadata values that are read, so
+// that large items such as cover art are skipped.
+const maxValueSize = 4096
+
+// Apple QuickTime metadata keys.
+const (
+	keyMake         = "com.apple.quicktime.make"
+	keyModel        = "com.apple.quicktime.model"
+	keyCreationDate = "com.apple.quicktime.creationdate"
+	keyLocation     = "com.apple.quicktime.location.ISO6709"
+)
+
+// topLevelBoxes are the box types that can open an ISO base media file.
+var topLevelBoxes = map[string]bool{
+	"ftyp": true,
+	"moov": true,
+	"mdat": true,
+	"free": true,
+	"skip": true,
+	"wide": true,
+	"pnot": true,
+}
+
+// Metadata is the metadata of a video.
+type Metadata struct {
+	// Created is the creation time of the movie header (or of the first
+	// track header if missing), which is stored in UTC.
+	Created time.Time
+	// CreationDate is the Apple creation date, in the time zone
+	// where the video has been recorded.
+	CreationDate time.Time
+	Make         string
+	Model        string
+	Latitude     float64
+	Longitude    float64
+	Altitude     float64
+	HasLocation  bool
+	HasAltitude  bool
+	Duration     time.Duration
+	// Width and Height are the size of the first visual track.
+	Width  int
+	Height int
+}
+
+// Time returns the creation time of the video, which is the zero
+// time if unknown.
+func (m *Metadata) Time() time.Time {
+	if !m.Created.IsZero() {
+		return m.Created
+	}
+	return m.CreationDate
+}
+
+type box struct {
+	typ       string
+	start     int64
+	dataStart int64
+	end       int64
+}
+
+// Supported checks whether a file is an ISO base media file, according
+// to its extension.
+func Supported(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".mp4" ||
+		ext == ".mov" ||
+		ext == ".m4v" ||
+		ext == ".3gp"
+}
+
+func readAt(r io.ReaderAt, offset int64, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	_, err := r.ReadAt(buf, offset)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readBoxes reads the headers of the boxes between start and end.
+// A truncated last box is ignored.
+func readBoxes(r io.ReaderAt, start, end int64) ([]box, error) {
+	var boxes []box
+	for offset := start; offset+8 <= end; {
+		header, err := readAt(r, offset, 8)
+		if err != nil {
+			return boxes, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		b := box{typ: string(header[4:8]), start: offset, dataStart: offset + 8}
+		switch size {
+		case 0:
+			// The box extends to the end of the file
+			size = end - offset
+		case 1:
+			large, err := readAt(r, offset+8, 8)
+			if err != nil {
+				return boxes, err
+			}
+			size = int64(binary.BigEndian.Uint64(large))
+			b.dataStart += 8
+		}
+		if size < b.dataStart-offset || offset+size > end {
+			break
+		}
+		b.end = offset + size
+		boxes = append(boxes, b)
+		offset = b.end
+	}
+	return boxes, nil
+}
+
+// readTime reads the creation time of a movie or track header.
+func readTime(r io.ReaderAt, b box) time.Time {
+	if b.end-b.dataStart < 12 {
+		return time.Time{}
+	}
+	data, err := readAt(r, b.dataStart, 12)
+	if err != nil {
+		return time.Time{}
+	}
+	var secs uint64
+	if data[0] == 1 {
+		secs = binary.BigEndian.Uint64(data[4:12])
+	} else {
+		secs = uint64(binary.BigEndian.Uint32(data[4:8]))
+	}
+	if secs <= epochOffset {
+		// Not set, or before 1970: surely wrong
+		return time.Time{}
+	}
+	return time.Unix(int64(secs-epochOffset), 0).UTC()
+}
+
+// readDuration reads the duration of a movie header.
+func readDuration(r io.ReaderAt, mvhd box) time.Duration {
+	if mvhd.end-mvhd.dataStart < 32 {
+		return 0
+	}
+	data, err := readAt(r, mvhd.dataStart, 32)
+	if err != nil {
+		return 0
+	}
+	var timescale, duration uint64
+	if data[0] == 1 {
+		timescale = uint64(binary.BigEndian.Uint32(data[20:24]))
+		duration = binary.BigEndian.Uint64(data[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(data[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+// readSize reads the width and height of a track header, which are
+// 16.16 fixed point numbers stored at its end.
+func readSize(r io.ReaderAt, tkhd box) (int, int) {
+	if tkhd.end-tkhd.dataStart < 84 {
+		return 0, 0
+	}
+	data, err := readAt(r, tkhd.end-8, 8)
+	if err != nil {
+		return 0, 0
+	}
+	return int(binary.BigEndian.Uint32(data[:4]) >> 16), int(binary.BigEndian.Uint32(data[4:]) >> 16)
+}
+
+// Parse reads the metadata of an ISO base media file (MP4, MOV, M4V and
+// 3GP videos) without external tools: the creation time stored in the
+// movie and track headers, the duration, the size of the video, the Apple QuickTime keys written by iPhones
+// (make, model, creation date and location) and the QuickTime user data.
+func Parse(path string) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	boxes, err := readBoxes(f, 0, info.Size())
+	if err != nil && len(boxes) == 0 {
+		return nil, err
+	}
+	if len(boxes) == 0 || !topLevelBoxes[boxes[0].typ] {
+		return nil, ErrUnsupported
+	}
+	meta := &Metadata{}
+	for _, b := range boxes {
+		if b.typ == "moov" {
+			err = parseMoov(f, b, meta)
+			return meta, err
+		}
+	}
+	return nil, fmt.Errorf("mp4: no movie box in %s", path)
+}
+
+func parseMoov(r io.ReaderAt, moov box, meta *Metadata) error {
+	children, err := readBoxes(r, moov.dataStart, moov.end)
+	if err != nil {
+		return err
+	}
+	var trackTime time.Time
+	for _, b := range children {
+		switch b.typ {
+		case "mvhd":
+			meta.Created = readTime(r, b)
+			meta.Duration = readDuration(r, b)
+		case "trak":
+			tracks, _ := readBoxes(r, b.dataStart, b.end)
+			for _, t := range tracks {
+				if t.typ != "tkhd" {
+					continue
+				}
+				if trackTime.IsZero() {
+					trackTime = readTime(r, t)
+				}
+				// Audio tracks have no size
+				if meta.Width == 0 {
+					meta.Width, meta.Height = readSize(r, t)
+				}
+			}
+		case "meta":
+			parseMeta(r, b, meta)
+		case "udta":
+			parseUserData(r, b, meta)
+		}
+	}
+	if meta.Created.IsZero() {
+		meta.Created = trackTime
+	}
+	return nil
+}
+
+// parseMeta reads the Apple QuickTime keys of a meta box.
+func parseMeta(r io.ReaderAt, meta box, m *Metadata) {
+	start := meta.dataStart
+	// The QuickTime meta box has no version and flags, unlike the MP4 one
+	peek, err := readAt(r, start, 8)
+	if err != nil {
+		return
+	}
+	if string(peek[4:8]) != "hdlr" {
+		start += 4
+	}
+	children, _ := readBoxes(r, start, meta.end)
+	var keys []string
+	for _, b := range children {
+		if b.typ == "keys" {
+			keys = readKeys(r, b)
+		}
+	}
+	for _, b := range children {
+		if b.typ != "ilst" {
+			continue
+		}
+		items, _ := readBoxes(r, b.dataStart, b.end)
+		for _, item := range items {
+			index := int(binary.BigEndian.Uint32([]byte(item.typ)))
+			if index < 1 || index > len(keys) {
+				continue
+			}
+			key := keys[index-1]
+			if key != keyMake && key != keyModel && key != keyCreationDate && key != keyLocation {
+				continue
+			}
+			value, ok := readData(r, item)
+			if ok {
+				m.set(key, value)
+			}
+		}
+	}
+}
+
+// readKeys reads the key names of a keys box.
+func readKeys(r io.ReaderAt, b box) []string {
+	header, err := readAt(r, b.dataStart, 8)
+	if err != nil {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint32(header[4:8]))
+	var keys []string
+	offset := b.dataStart + 8
+	for i := 0; i < count && offset+8 <= b.end; i++ {
+		entry, err := readAt(r, offset, 8)
+		if err != nil {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(entry[:4]))
+		if size < 8 || offset+size > b.end || size > maxValueSize {
+			break
+		}
+		name, err := readAt(r, offset+8, size-8)
+		if err != nil {
+			break
+		}
+		keys = append(keys, string(name))
+		offset += size
+	}
+	return keys
+}
+
+// readData reads the value of the data box of a metadata item.
+func readData(r io.ReaderAt, item box) (string, bool) {
+	children, _ := readBoxes(r, item.dataStart, item.end)
+	for _, b := range children {
+		if b.typ != "data" {
+			continue
+		}
+		size := b.end - b.dataStart - 8
+		if size < 0 || size > maxValueSize {
+			return "", false
+		}
+		value, err := readAt(r, b.dataStart+8, size)
+		if err != nil {
+			return "", false
+		}
+		return strings.TrimRight(string(value), "\x00"), true
+	}
+	return "", false
+}
+
+// parseUserData reads the QuickTime user data: make, model and location
+// stored as international text, and the meta box that some files put here.
+func parseUserData(r io.ReaderAt, udta box, m *Metadata) {
+	children, _ := readBoxes(r, udta.dataStart, udta.end)
+	for _, b := range children {
+		var key string
+		switch b.typ {
+		case "\xa9mak":
+			key = keyMake
+		case "\xa9mod":
+			key = keyModel
+		case "\xa9xyz":
+			key = keyLocation
+		case "meta":
+			parseMeta(r, b, m)
+			continue
+		default:
+			continue
+		}
+		size := b.end - b.dataStart
+		if size < 4 || size > maxValueSize {
+			continue
+		}
+		data, err := readAt(r, b.dataStart, size)
+		if err != nil {
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[:2]))
+		if 4+length > len(data) {
+			length = len(data) - 4
+		}
+		// The values from the Apple keys take precedence
+		if (key == keyMake && m.Make == "") || (key == keyModel && m.Model == "") || (key == keyLocation && !m.HasLocation) {
+			m.set(key, string(data[4:4+length]))
+		}
+	}
+}
+
+// iso6709 matches the latitude and longitude of an ISO 6709 location
+// in decimal degrees, optionally followed by the altitude in meters,
+// e.g. +45.4642+009.1900+122.000/.
+var iso6709 = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)?`)
+
+func (m *Metadata) set(key, value string) {
+	value = strings.TrimSpace(value)
+	switch key {
+	case keyMake:
+		m.Make = value
+	case keyModel:
+		m.Model = value
+	case keyCreationDate:
+		for _, layout := range []string{"2006-01-02T15:04:05-0700", time.RFC3339} {
+			tm, err := time.Parse(layout, value)
+			if err == nil {
+				m.CreationDate = tm
+				break
+			}
+		}
+	case keyLocation:
+		match := iso6709.FindStringSubmatch(value)
+		if match == nil {
+			return
+		}
+		lat, err1 := strconv.ParseFloat(match[1], 64)
+		lon, err2 := strconv.ParseFloat(match[2], 64)
+		if err1 == nil && err2 == nil {
+			m.Latitude, m.Longitude, m.HasLocation = lat, lon, true
+		}
+		if alt, err := strconv.ParseFloat(match[3], 64); err == nil {
+			m.Altitude, m.HasAltitude = alt, true
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mkbox builds a box with a 32-bit size.
+func mkbox(typ string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	return append(append(u32(uint32(8+len(data))), typ...), data...)
+}
+
+// mklargebox builds a box with a 64-bit size.
+func mklargebox(typ string, payload []byte) []byte {
+	box := append(append(u32(1), typ...), u64(uint64(16+len(payload)))...)
+	return append(box, payload...)
+}
+
+// file concatenates the boxes of a file.
+func file(boxes ...[]byte) []byte {
+	return bytes.Join(boxes, nil)
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// quickTime converts a time to seconds since 1904-01-01.
+func quickTime(tm time.Time) uint64 {
+	return uint64(tm.Unix() + epochOffset)
+}
+
+var (
+	created = time.Date(2021, 6, 5, 14, 30, 0, 0, time.UTC)
+	ftyp    = mkbox("ftyp", []byte("qt  "), u32(0))
+)
+
+func mvhd0(tm time.Time, timescale, duration uint32) []byte {
+	return mkbox("mvhd", u32(0), u32(uint32(quickTime(tm))), u32(0), u32(timescale), u32(duration), make([]byte, 80))
+}
+
+func mvhd1(tm time.Time, timescale uint32, duration uint64) []byte {
+	return mkbox("mvhd", u32(1<<24), u64(quickTime(tm)), u64(0), u32(timescale), u64(duration), make([]byte, 80))
+}
+
+func tkhd0(tm time.Time, width, height uint16) []byte {
+	return mkbox("tkhd", u32(0), u32(uint32(quickTime(tm))), make([]byte, 68), u32(uint32(width)<<16), u32(uint32(height)<<16))
+}
+
+func tkhd1(tm time.Time, width, height uint16) []byte {
+	return mkbox("tkhd", u32(1<<24), u64(quickTime(tm)), make([]byte, 76), u32(uint32(width)<<16), u32(uint32(height)<<16))
+}
+
+// appleMeta builds a QuickTime meta box with the specified keys and values.
+func appleMeta(pairs ...string) []byte {
+	var keys, items [][]byte
+	for i := 0; i+1 < len(pairs); i += 2 {
+		keys = append(keys, append(append(u32(uint32(8+len(pairs[i]))), "mdta"...), pairs[i]...))
+		value := mkbox("data", u32(1), u32(0), []byte(pairs[i+1]))
+		items = append(items, mkbox(string(u32(uint32(i/2+1))), value))
+	}
+	hdlr := mkbox("hdlr", u32(0), u32(0), []byte("mdta"), make([]byte, 13))
+	keysBox := mkbox("keys", u32(0), u32(uint32(len(keys))), bytes.Join(keys, nil))
+	return mkbox("meta", hdlr, keysBox, mkbox("ilst", items...))
+}
+
+// userText builds a QuickTime user data item with international text.
+func userText(typ, text string) []byte {
+	return mkbox(typ, u16(uint16(len(text))), u16(0x15c7), []byte(text))
+}
+
+func writeFile(t *testing.T, data []byte) string {
+	path := filepath.Join(t.TempDir(), "video.mov")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParse(t *testing.T) {
+	moov := mkbox("moov", mvhd0(created, 600, 3000))
+	tests := []struct {
+		name string
+		data []byte
+		want Metadata
+	}{
+		{
+			name: "version 0 movie header",
+			data: file(ftyp, moov),
+			want: Metadata{Created: created, Duration: 5 * time.Second},
+		},
+		{
+			name: "version 1 headers",
+			data: file(ftyp, mkbox("moov", mvhd1(created, 1000, 2500), mkbox("trak", tkhd1(created, 3840, 2160)))),
+			want: Metadata{Created: created, Duration: 2500 * time.Millisecond, Width: 3840, Height: 2160},
+		},
+		{
+			name: "track header only",
+			data: file(ftyp, mkbox("moov", mkbox("trak", tkhd0(created, 1920, 1080)))),
+			want: Metadata{Created: created, Width: 1920, Height: 1080},
+		},
+		{
+			name: "audio track first",
+			data: file(ftyp, mkbox("moov", mvhd0(created, 1, 1), mkbox("trak", tkhd0(created, 0, 0)), mkbox("trak", tkhd0(created, 640, 480)))),
+			want: Metadata{Created: created, Duration: time.Second, Width: 640, Height: 480},
+		},
+		{
+			name: "unset creation time",
+			data: file(ftyp, mkbox("moov", mvhd0(time.Unix(-epochOffset, 0), 0, 10))),
+			want: Metadata{},
+		},
+		{
+			name: "large media data before the movie",
+			data: file(ftyp, mklargebox("mdat", make([]byte, 32)), moov),
+			want: Metadata{Created: created, Duration: 5 * time.Second},
+		},
+		{
+			name: "movie extending to the end of the file",
+			data: file(ftyp, u32(0), moov[4:]),
+			want: Metadata{Created: created, Duration: 5 * time.Second},
+		},
+		{
+			name: "truncated movie header",
+			data: file(ftyp, mkbox("moov", mkbox("mvhd", u32(0), u32(uint32(quickTime(created)))), mkbox("trak", tkhd0(created.Add(time.Hour), 640, 480)))),
+			want: Metadata{Created: created.Add(time.Hour), Width: 640, Height: 480},
+		},
+		{
+			name: "truncated track header",
+			data: file(ftyp, mkbox("moov", mkbox("trak", mkbox("tkhd", u32(0), u32(uint32(quickTime(created))), make([]byte, 40))))),
+			want: Metadata{Created: created},
+		},
+		{
+			name: "truncated child box",
+			data: file(ftyp, mkbox("moov", mvhd0(created, 600, 3000), append(u32(1000), "trak"...))),
+			want: Metadata{Created: created, Duration: 5 * time.Second},
+		},
+		{
+			name: "Apple keys",
+			data: file(ftyp, mkbox("moov", mvhd0(created, 600, 3000), appleMeta(
+				keyMake, "Apple",
+				keyModel, "iPhone 12",
+				"com.apple.quicktime.software", "14.6",
+				keyCreationDate, "2021-06-05T16:30:00+0200",
+				keyLocation, "+45.4642+009.1900+122.000/"))),
+			want: Metadata{
+				Created:      created,
+				CreationDate: time.Date(2021, 6, 5, 16, 30, 0, 0, time.FixedZone("", 7200)),
+				Make:         "Apple",
+				Model:        "iPhone 12",
+				Latitude:     45.4642,
+				Longitude:    9.19,
+				Altitude:     122,
+				HasLocation:  true,
+				HasAltitude:  true,
+				Duration:     5 * time.Second,
+			},
+		},
+		{
+			name: "user data",
+			data: file(ftyp, mkbox("moov", mvhd0(created, 600, 3000), mkbox("udta",
+				userText("\xa9mak", "SONY"),
+				userText("\xa9mod", "ILCE-7M3"),
+				userText("\xa9xyz", "-33.8688+151.2093/")))),
+			want: Metadata{
+				Created:     created,
+				Make:        "SONY",
+				Model:       "ILCE-7M3",
+				Latitude:    -33.8688,
+				Longitude:   151.2093,
+				HasLocation: true,
+				Duration:    5 * time.Second,
+			},
+		},
+		{
+			name: "Apple keys take precedence over user data",
+			data: file(ftyp, mkbox("moov", appleMeta(keyModel, "iPhone 12"), mkbox("udta", userText("\xa9mod", "Other")))),
+			want: Metadata{Model: "iPhone 12"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta, err := Parse(writeFile(t, test.data))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !meta.Created.Equal(test.want.Created) || !meta.CreationDate.Equal(test.want.CreationDate) {
+				t.Errorf("times = %v, %v; want %v, %v", meta.Created, meta.CreationDate, test.want.Created, test.want.CreationDate)
+			}
+			if !test.want.CreationDate.IsZero() && meta.CreationDate.Format("-07:00") != test.want.CreationDate.Format("-07:00") {
+				t.Errorf("creation date offset = %s, want %s", meta.CreationDate.Format("-07:00"), test.want.CreationDate.Format("-07:00"))
+			}
+			meta.Created, meta.CreationDate = test.want.Created, test.want.CreationDate
+			if *meta != test.want {
+				t.Errorf("Parse = %+v, want %+v", *meta, test.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty file", nil},
+		{"not a media file", []byte("\xff\xd8\xff\xe0 this is a JPEG")},
+		{"no movie box", file(ftyp, mkbox("mdat", make([]byte, 16)))},
+		{"truncated movie box", file(ftyp, mkbox("moov", mvhd0(created, 600, 3000))[:50])},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if meta, err := Parse(writeFile(t, test.data)); err == nil {
+				t.Errorf("Parse = %+v, want an error", *meta)
+			}
+		})
+	}
+}
+
+func TestISO6709(t *testing.T) {
+	tests := []struct {
+		value       string
+		lat, lon    float64
+		alt         float64
+		hasLocation bool
+		hasAltitude bool
+	}{
+		{"+45.4642+009.1900+122.000/", 45.4642, 9.19, 122, true, true},
+		{"+45.4642+009.1900/", 45.4642, 9.19, 0, true, false},
+		{"-33.8688+151.2093-004.5/", -33.8688, 151.2093, -4.5, true, true},
+		{"+40-074/", 40, -74, 0, true, false},
+		{"45.4642,9.1900", 0, 0, 0, false, false},
+		{"", 0, 0, 0, false, false},
+	}
+	for _, test := range tests {
+		var m Metadata
+		m.set(keyLocation, test.value)
+		if m.Latitude != test.lat || m.Longitude != test.lon || m.Altitude != test.alt ||
+			m.HasLocation != test.hasLocation || m.HasAltitude != test.hasAltitude {
+			t.Errorf("%q: got %+v", test.value, m)
+		}
+	}
+}
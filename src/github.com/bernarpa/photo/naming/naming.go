@@ -0,0 +1,184 @@
+package naming
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Default templates, matching the historical Photo naming scheme:
+// 2006-01-02_15-04-05.jpg files in 2006-01-02 daily folders.
+const (
+	DefaultRename = "{YYYY}-{MM}-{DD}_{hh}-{mm}-{ss}"
+	DefaultFolder = "{YYYY}-{MM}-{DD}"
+)
+
+// Placeholders lists the supported placeholders with their description.
+var Placeholders = map[string]string{
+	"YYYY":    "year (4 digits)",
+	"YY":      "year (2 digits)",
+	"MM":      "month (2 digits)",
+	"DD":      "day (2 digits)",
+	"hh":      "hours (2 digits)",
+	"mm":      "minutes (2 digits)",
+	"ss":      "seconds (2 digits)",
+	"make":    "camera make",
+	"model":   "camera model",
+	"camera":  "camera make and model",
+	"name":    "original file name, without extension",
+	"counter": "progressive number of the file in the operation (4 digits)",
+	"kind":    "photo or video",
+}
+
+// Fields are the values that replace the placeholders of a Template.
+type Fields struct {
+	Time    time.Time
+	Make    string
+	Model   string
+	Camera  string
+	Name    string
+	Counter int
+	Kind    string
+}
+
+type part struct {
+	literal     string
+	placeholder string
+}
+
+// Template is a file or folder name with {placeholders}, e.g.
+// {YYYY}/{YYYY}-{MM}-{DD} or {YYYY}{MM}{DD}_{hh}{mm}{ss}_{model}.
+type Template struct {
+	raw   string
+	parts []part
+}
+
+// Parse parses a template, checking that the placeholders are valid.
+// Folder templates can use / to create nested folders, whereas file
+// name templates can't contain any path separator.
+func Parse(template string, folder bool) (*Template, error) {
+	if strings.TrimSpace(template) == "" {
+		return nil, errors.New("empty template")
+	}
+	if strings.Contains(template, "\\") {
+		return nil, errors.New("use / as path separator")
+	}
+	if !folder && strings.Contains(template, "/") {
+		return nil, errors.New("file name templates can't contain /")
+	}
+	if folder {
+		if strings.HasPrefix(template, "/") {
+			return nil, errors.New("folder templates must be relative")
+		}
+		for _, dir := range strings.Split(template, "/") {
+			if dir == "" || dir == "." || dir == ".." {
+				return nil, fmt.Errorf("invalid folder name %q", dir)
+			}
+		}
+	}
+	t := &Template{raw: template}
+	rest := template
+	for rest != "" {
+		open := strings.Index(rest, "{")
+		if open < 0 {
+			if strings.Contains(rest, "}") {
+				return nil, errors.New("unbalanced }")
+			}
+			t.parts = append(t.parts, part{literal: rest})
+			break
+		}
+		if strings.Contains(rest[:open], "}") {
+			return nil, errors.New("unbalanced }")
+		}
+		if open > 0 {
+			t.parts = append(t.parts, part{literal: rest[:open]})
+		}
+		close := strings.Index(rest[open:], "}")
+		if close < 0 {
+			return nil, errors.New("unbalanced {")
+		}
+		name := rest[open+1 : open+close]
+		if _, exists := Placeholders[name]; !exists {
+			return nil, fmt.Errorf("unknown placeholder {%s}", name)
+		}
+		t.parts = append(t.parts, part{placeholder: name})
+		rest = rest[open+close+1:]
+	}
+	return t, nil
+}
+
+// MustParse parses a template that is known to be valid, it panics otherwise.
+func MustParse(template string, folder bool) *Template {
+	t, err := Parse(template, folder)
+	if err != nil {
+		panic(fmt.Sprintf("invalid template %q: %s", template, err.Error()))
+	}
+	return t
+}
+
+// String returns the template as it was written.
+func (t *Template) String() string {
+	return t.raw
+}
+
+// sanitize makes a value safe to be used in a file name.
+func sanitize(value string, def string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return def
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+func (f *Fields) value(placeholder string) string {
+	switch placeholder {
+	case "YYYY":
+		return f.Time.Format("2006")
+	case "YY":
+		return f.Time.Format("06")
+	case "MM":
+		return f.Time.Format("01")
+	case "DD":
+		return f.Time.Format("02")
+	case "hh":
+		return f.Time.Format("15")
+	case "mm":
+		return f.Time.Format("04")
+	case "ss":
+		return f.Time.Format("05")
+	case "make":
+		return sanitize(f.Make, "Unknown")
+	case "model":
+		return sanitize(f.Model, "Unknown")
+	case "camera":
+		return sanitize(f.Camera, "Unknown")
+	case "name":
+		return sanitize(f.Name, "")
+	case "counter":
+		return fmt.Sprintf("%04d", f.Counter)
+	case "kind":
+		return sanitize(f.Kind, "photo")
+	}
+	return ""
+}
+
+// Format fills the template with the specified values. Folder templates
+// are returned with the path separator of the operating system.
+func (t *Template) Format(f Fields) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.placeholder != "" {
+			b.WriteString(f.value(p.placeholder))
+		} else {
+			b.WriteString(p.literal)
+		}
+	}
+	return filepath.FromSlash(b.String())
+}
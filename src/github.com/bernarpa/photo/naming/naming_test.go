@@ -0,0 +1,86 @@
+package naming
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var fields = Fields{
+	Time:    time.Date(2021, 6, 5, 8, 3, 9, 0, time.UTC),
+	Make:    "Apple",
+	Model:   "iPhone 12",
+	Camera:  "Apple iPhone 12",
+	Name:    "IMG_0042",
+	Counter: 7,
+	Kind:    "video",
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		template string
+		folder   bool
+		fields   Fields
+		want     string
+	}{
+		{DefaultRename, false, fields, "2021-06-05_08-03-09"},
+		{DefaultFolder, true, fields, "2021-06-05"},
+		{"{YYYY}/{YYYY}-{MM}-{DD}", true, fields, filepath.Join("2021", "2021-06-05")},
+		{"{YY}{MM}{DD}_{hh}{mm}{ss}_{model}", false, fields, "210605_080309_iPhone 12"},
+		{"{counter}_{name}", false, fields, "0007_IMG_0042"},
+		{"{kind}s/{camera}", true, fields, filepath.Join("videos", "Apple iPhone 12")},
+		{"{make}-{model}-{kind}", false, Fields{Make: "  ", Model: ""}, "Unknown-Unknown-photo"},
+		{"{camera}", true, Fields{Camera: `Cam/Era: "X"*`}, `Cam_Era_ _X__`},
+		{"{name}.bak", false, Fields{Name: "a\tb"}, "a_b.bak"},
+		{"no placeholders", false, fields, "no placeholders"},
+	}
+	for _, test := range tests {
+		template, err := Parse(test.template, test.folder)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.template, err)
+			continue
+		}
+		if template.String() != test.template {
+			t.Errorf("String() = %q, want %q", template.String(), test.template)
+		}
+		if got := template.Format(test.fields); got != test.want {
+			t.Errorf("Format(%q) = %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		template string
+		folder   bool
+	}{
+		{"", false},
+		{"   ", true},
+		{"{YYYY}/{MM}", false},
+		{`{YYYY}\{MM}`, true},
+		{"/{YYYY}", true},
+		{"{YYYY}//{MM}", true},
+		{"{YYYY}/../{MM}", true},
+		{"{YYYY}/", true},
+		{"{year}", false},
+		{"{YYYY", false},
+		{"YYYY}", false},
+		{"{YYYY}}", false},
+		{"}{YYYY}", false},
+		{"{}", false},
+	}
+	for _, test := range tests {
+		if _, err := Parse(test.template, test.folder); err == nil {
+			t.Errorf("Parse(%q, %v) succeeded, want an error", test.template, test.folder)
+		}
+	}
+}
+
+func TestMustParse(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("MustParse of an invalid template didn't panic")
+		}
+	}()
+	MustParse("{unknown}", false)
+}
@@ -0,0 +1,122 @@
+package operations
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// cmdArgs holds the command line arguments that follow the operation
+// name, split into positional arguments and --options.
+type cmdArgs struct {
+	positional []string
+	options    map[string][]string
+	help       helpFunction
+}
+
+// parseArgs parses the arguments that follow the operation name. The
+// options listed in flags don't take a value, whereas these listed in
+// valueOptions do, either as --name value or as --name=value.
+func parseArgs(args []string, flags []string, valueOptions []string) (*cmdArgs, error) {
+	isFlag := make(map[string]bool)
+	for _, f := range flags {
+		isFlag[f] = true
+	}
+	isValue := make(map[string]bool)
+	for _, v := range valueOptions {
+		isValue[v] = true
+	}
+	parsed := &cmdArgs{options: make(map[string][]string)}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") {
+			parsed.positional = append(parsed.positional, arg)
+			continue
+		}
+		name := strings.TrimPrefix(arg, "--")
+		value := ""
+		hasValue := false
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value, hasValue = name[:eq], name[eq+1:], true
+		}
+		switch {
+		case isFlag[name]:
+			if hasValue {
+				return nil, fmt.Errorf("option --%s doesn't take a value", name)
+			}
+			parsed.options[name] = append(parsed.options[name], "")
+		case isValue[name]:
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("option --%s requires a value", name)
+				}
+				i++
+				value = args[i]
+			}
+			parsed.options[name] = append(parsed.options[name], value)
+		default:
+			return nil, fmt.Errorf("unknown option: --%s", name)
+		}
+	}
+	return parsed, nil
+}
+
+// mustParseArgs parses the arguments that follow the operation name,
+// or shows the help and Exit(1) if they aren't valid.
+func mustParseArgs(help helpFunction, flags []string, valueOptions []string) *cmdArgs {
+	parsed, err := parseArgs(os.Args[2:], flags, valueOptions)
+	if err != nil {
+		fmt.Println(err.Error())
+		help()
+		os.Exit(1)
+	}
+	parsed.help = help
+	return parsed
+}
+
+// arg returns the i-th positional argument, or def if it's missing.
+func (a *cmdArgs) arg(i int, def string) string {
+	if i < len(a.positional) {
+		return a.positional[i]
+	}
+	return def
+}
+
+// flag reports whether the --name option has been specified.
+func (a *cmdArgs) flag(name string) bool {
+	_, exists := a.options[name]
+	return exists
+}
+
+// value returns the value of the --name option, or def if it's missing.
+// If the option has been specified more than once the last value wins.
+func (a *cmdArgs) value(name string, def string) string {
+	values := a.options[name]
+	if len(values) == 0 {
+		return def
+	}
+	return values[len(values)-1]
+}
+
+// values returns all the values of a repeatable --name option.
+func (a *cmdArgs) values(name string) []string {
+	return a.options[name]
+}
+
+// choice returns the value of the --name option, which must be one of
+// the allowed values (the first one is the default), or shows the help
+// and Exit(1) if it isn't.
+func (a *cmdArgs) choice(name string, allowed ...string) string {
+	value := a.value(name, allowed[0])
+	for _, v := range allowed {
+		if v == value {
+			return value
+		}
+	}
+	fmt.Printf("Invalid value for --%s: %s (allowed: %s)\n", name, value, strings.Join(allowed, ", "))
+	if a.help != nil {
+		a.help()
+	}
+	os.Exit(1)
+	return ""
+}
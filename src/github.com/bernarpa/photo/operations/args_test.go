@@ -0,0 +1,94 @@
+package operations
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	flags := []string{"dry-run", "purge"}
+	valueOptions := []string{"format", "gpx"}
+	tests := []struct {
+		name       string
+		args       []string
+		positional []string
+		options    map[string][]string
+	}{
+		{"no arguments", nil, nil, map[string][]string{}},
+		{"positional", []string{"nas", "/tmp/inbox"}, []string{"nas", "/tmp/inbox"}, map[string][]string{}},
+		{"flag", []string{"nas", "--dry-run"}, []string{"nas"}, map[string][]string{"dry-run": {""}}},
+		{"separate value", []string{"--format", "json", "nas"}, []string{"nas"}, map[string][]string{"format": {"json"}}},
+		{"inline value", []string{"nas", "--format=json"}, []string{"nas"}, map[string][]string{"format": {"json"}}},
+		{"empty inline value", []string{"--format="}, nil, map[string][]string{"format": {""}}},
+		{"inline value with =", []string{"--format=a=b"}, nil, map[string][]string{"format": {"a=b"}}},
+		{"value looking like an option", []string{"--format", "--dry-run"}, nil, map[string][]string{"format": {"--dry-run"}}},
+		{"single dash is positional", []string{"-", "-x"}, []string{"-", "-x"}, map[string][]string{}},
+		{
+			"repeated options",
+			[]string{"--gpx", "a.gpx", "nas", "--gpx=b.gpx", "--dry-run", "--dry-run"},
+			[]string{"nas"},
+			map[string][]string{"gpx": {"a.gpx", "b.gpx"}, "dry-run": {"", ""}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := parseArgs(test.args, flags, valueOptions)
+			if err != nil {
+				t.Fatalf("parseArgs: %v", err)
+			}
+			if !reflect.DeepEqual(parsed.positional, test.positional) {
+				t.Errorf("positional = %q, want %q", parsed.positional, test.positional)
+			}
+			if !reflect.DeepEqual(parsed.options, test.options) {
+				t.Errorf("options = %q, want %q", parsed.options, test.options)
+			}
+		})
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown option", []string{"nas", "--verbose"}},
+		{"flag with value", []string{"--dry-run=yes"}},
+		{"missing value", []string{"nas", "--format"}},
+		{"empty option name", []string{"--"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if parsed, err := parseArgs(test.args, []string{"dry-run"}, []string{"format"}); err == nil {
+				t.Errorf("parseArgs = %+v, want an error", parsed)
+			}
+		})
+	}
+}
+
+func TestCmdArgsAccessors(t *testing.T) {
+	parsed, err := parseArgs([]string{"nas", "--gpx", "a.gpx", "--gpx=b.gpx", "--dry-run"}, []string{"dry-run"}, []string{"gpx", "format"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed.arg(0, ""); got != "nas" {
+		t.Errorf("arg(0) = %q, want nas", got)
+	}
+	if got := parsed.arg(1, "default"); got != "default" {
+		t.Errorf("arg(1) = %q, want default", got)
+	}
+	if !parsed.flag("dry-run") || parsed.flag("format") {
+		t.Errorf("flag: dry-run = %v, format = %v", parsed.flag("dry-run"), parsed.flag("format"))
+	}
+	if got := parsed.value("gpx", ""); got != "b.gpx" {
+		t.Errorf("value(gpx) = %q, want the last value b.gpx", got)
+	}
+	if got := parsed.value("format", "text"); got != "text" {
+		t.Errorf("value(format) = %q, want the default text", got)
+	}
+	if got := parsed.values("gpx"); !reflect.DeepEqual(got, []string{"a.gpx", "b.gpx"}) {
+		t.Errorf("values(gpx) = %q", got)
+	}
+	if got := parsed.choice("format", "text", "json"); got != "text" {
+		t.Errorf("choice(format) = %q, want text", got)
+	}
+}
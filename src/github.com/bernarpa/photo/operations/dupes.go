@@ -0,0 +1,270 @@
+package operations
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+)
+
+// ShowHelpDupes prints the help for the dupes operation.
+func ShowHelpDupes() {
+	fmt.Println()
+	fmt.Println("Usage: photo dupes <TARGET> [--match content|metadata] [--keep oldest|shortest|collection] [--prefer DIR]")
+	fmt.Println("                   [--format text|json] [--move DIR | --hardlink] [--dry-run]")
+	fmt.Println()
+	fmt.Println("   TARGET      one of the targets defined in config.json")
+	fmt.Println("   --match     group identical files (content, default) or photos with the")
+	fmt.Println("               same camera and timestamp (metadata)")
+	fmt.Println("   --keep      copy to keep in each group: the oldest file (default), the")
+	fmt.Println("               shortest path or the one in the preferred collection")
+	fmt.Println("   --prefer    preferred collection for --keep collection, by default the")
+	fmt.Println("               first one listed in config.json")
+	fmt.Println("   --format    output format of the report, text (default) or json")
+	fmt.Println("   --move      move the redundant copies to DIR (local targets only)")
+	fmt.Println("   --hardlink  replace the redundant copies with hard links to the kept one")
+	fmt.Println("               (local targets and --match content only); the replaced copies")
+	fmt.Println("               are moved to the trash of the journal, so the space is freed")
+	fmt.Println("               only by photo undo --purge")
+	fmt.Println("   --dry-run   print the planned changes without touching the filesystem")
+	fmt.Println()
+}
+
+// dupesGroup is a group of copies of the same photo.
+type dupesGroup struct {
+	Hash       string        `json:"hash"`
+	Wasted     int64         `json:"wasted"`
+	Keep       cache.Photo   `json:"keep"`
+	Duplicates []cache.Photo `json:"duplicates"`
+}
+
+// dupesReport lists the groups of duplicates of a target.
+type dupesReport struct {
+	Groups []dupesGroup `json:"groups"`
+	Wasted int64        `json:"wasted"`
+}
+
+// formatBytes returns a human readable size, e.g. 1.5 MB.
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// inDir checks whether a path is inside a directory.
+func inDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// keepFirst sorts a group of duplicates so that the copy to keep,
+// according to the keep policy, comes first.
+func keepFirst(photos []cache.Photo, keep string, prefer string) {
+	sort.Slice(photos, func(a, b int) bool {
+		pa, pb := photos[a], photos[b]
+		if keep == "collection" {
+			ia, ib := inDir(pa.Path, prefer), inDir(pb.Path, prefer)
+			if ia != ib {
+				return ia
+			}
+		}
+		if keep == "shortest" && len(pa.Path) != len(pb.Path) {
+			return len(pa.Path) < len(pb.Path)
+		}
+		if keep != "shortest" && pa.ModTime != pb.ModTime {
+			return pa.ModTime < pb.ModTime
+		}
+		return pa.Path < pb.Path
+	})
+}
+
+// findDupes groups the photos of a cache by content or metadata hash.
+// For local targets the copies that are hard links to the kept one are
+// left out, and those linked to another copy aren't counted as wasted.
+func findDupes(myCache *cache.Cache, match string, keep string, prefer string, local bool) *dupesReport {
+	byHash := make(map[string][]cache.Photo)
+	for _, photo := range myCache.Photos {
+		if photo.Ignored {
+			continue
+		}
+		hash := photo.SHA256
+		if match == matchMetadata {
+			hash = photo.Hash
+		}
+		if hash != "" {
+			byHash[hash] = append(byHash[hash], photo)
+		}
+	}
+	report := &dupesReport{Groups: []dupesGroup{}}
+	for hash, photos := range byHash {
+		if len(photos) < 2 {
+			continue
+		}
+		keepFirst(photos, keep, prefer)
+		group := dupesGroup{Hash: hash, Keep: photos[0]}
+		var kept os.FileInfo
+		if local {
+			kept, _ = os.Stat(group.Keep.Path)
+		}
+		// The copies already counted as wasted
+		var counted []os.FileInfo
+		for _, photo := range photos[1:] {
+			wasted := true
+			if local {
+				if info, err := os.Stat(photo.Path); err == nil {
+					if kept != nil && os.SameFile(info, kept) {
+						continue
+					}
+					for _, other := range counted {
+						if os.SameFile(info, other) {
+							wasted = false
+						}
+					}
+					counted = append(counted, info)
+				}
+			}
+			group.Duplicates = append(group.Duplicates, photo)
+			if wasted {
+				group.Wasted += photo.Size
+			}
+		}
+		if len(group.Duplicates) == 0 {
+			continue
+		}
+		report.Wasted += group.Wasted
+		report.Groups = append(report.Groups, group)
+	}
+	// The groups that waste more space come first
+	sort.Slice(report.Groups, func(a, b int) bool {
+		ga, gb := report.Groups[a], report.Groups[b]
+		if ga.Wasted != gb.Wasted {
+			return ga.Wasted > gb.Wasted
+		}
+		return ga.Keep.Path < gb.Keep.Path
+	})
+	return report
+}
+
+// Print prints the report in the specified format (text or json).
+func (r *dupesReport) Print(format string) {
+	if format == "json" {
+		out, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Println(string(out))
+		return
+	}
+	for i, group := range r.Groups {
+		fmt.Printf("Group %d: %d copies, %s wasted\n", i+1, len(group.Duplicates)+1, formatBytes(group.Wasted))
+		fmt.Printf("  keep  %s\n", group.Keep.Path)
+		for _, photo := range group.Duplicates {
+			fmt.Printf("  dupe  %s\n", photo.Path)
+		}
+	}
+	fmt.Printf("%d groups of duplicates, %s wasted\n", len(r.Groups), formatBytes(r.Wasted))
+}
+
+// collectionOf returns the collection of the target that contains a path.
+func collectionOf(target *config.Target, path string) string {
+	for _, collection := range target.Collections {
+		if inDir(path, collection) {
+			return collection
+		}
+	}
+	return filepath.Dir(path)
+}
+
+// Dupes finds the photos that are stored more than once in the collections
+// of a target and, optionally, moves the redundant copies away or replaces
+// them with hard links.
+func Dupes(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpDupes, []string{"hardlink", "dry-run"}, []string{"match", "keep", "prefer", "format", "move"})
+	match := args.choice("match", matchContent, matchMetadata)
+	keep := args.choice("keep", "oldest", "shortest", "collection")
+	format := args.choice("format", "text", "json")
+	moveDir := args.value("move", "")
+	hardlink := args.flag("hardlink")
+	prefer := ""
+	if len(target.Collections) > 0 {
+		prefer = target.Collections[0]
+	}
+	prefer = args.value("prefer", prefer)
+	if moveDir != "" && hardlink {
+		log.Fatal("--move and --hardlink can't be used together")
+	}
+	if (moveDir != "" || hardlink) && target.TargetType != "local" {
+		log.Fatal("--move and --hardlink are only supported for local targets")
+	}
+	if hardlink && match != matchContent {
+		log.Fatal("--hardlink requires --match content, only identical files can be linked")
+	}
+	myCache := loadLocalCache(conf, target)
+	report := findDupes(myCache, match, keep, prefer, target.TargetType == "local")
+	report.Print(format)
+	if moveDir == "" && !hardlink {
+		return
+	}
+	dryRun := args.flag("dry-run")
+	ops, done := fileOperations(dryRun, "text")
+	defer done()
+	// The new paths of the moved copies, or the same path for the copies
+	// replaced by links, whose modification time changes
+	moved := make(map[string]string)
+	for _, group := range report.Groups {
+		for _, photo := range group.Duplicates {
+			if moveDir != "" {
+				// Keep the folder structure of the collection
+				collection := collectionOf(target, photo.Path)
+				rel, err := filepath.Rel(collection, filepath.Dir(photo.Path))
+				if err != nil {
+					rel = ""
+				}
+				dir := filepath.Join(moveDir, filepath.Base(collection), rel)
+				ops.MkdirAll(dir)
+				oldPath := photo.Path
+				err = photo.MoveTo(dir, ops)
+				if err != nil {
+					log.Printf("Warning: unable to move photo %s to %s: %s\n", photo.Path, dir, err.Error())
+				} else if photo.Path != oldPath {
+					moved[oldPath] = photo.Path
+				}
+				continue
+			}
+			// The link is created next to the copy and then renamed over
+			// it, so that a failure (e.g. different filesystems) leaves
+			// the copy untouched
+			tmp := photo.Path + ".photolink"
+			err := ops.Link(group.Keep.Path, tmp)
+			if err != nil {
+				log.Printf("Warning: unable to link %s to %s: %s\n", photo.Path, group.Keep.Path, err.Error())
+				continue
+			}
+			err = ops.Remove(photo.Path)
+			if err == nil {
+				err = ops.Rename(tmp, photo.Path)
+			}
+			if err != nil {
+				log.Printf("Warning: unable to replace %s with a link: %s\n", photo.Path, err.Error())
+				continue
+			}
+			moved[photo.Path] = photo.Path
+		}
+	}
+	if !dryRun {
+		updateMovedPhotos(target, myCache, moved)
+	}
+}
@@ -5,85 +5,274 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/bernarpa/photo/cache"
 	"github.com/bernarpa/photo/config"
 	"github.com/bernarpa/photo/exiftool"
-	"github.com/bernarpa/photo/utils"
+	"github.com/bernarpa/photo/fileops"
 )
 
 // ShowHelpFilter prints the help for the stats operation.
 func ShowHelpFilter() {
 	fmt.Println()
-	fmt.Println("Usage: photo filter <TARGET> [directory]")
+	fmt.Println("Usage: photo filter <TARGET> [directory] [--remote] [--match both|metadata|content] [--dry-run] [--format text|json]")
 	fmt.Println()
 	fmt.Println("   TARGET     one of the targets defined in config.json")
 	fmt.Println("   directory  local directory with the photos to be filtered")
+	fmt.Println("   --remote   the directory is on the SSH target, where the photos are")
+	fmt.Println("              filtered without transferring them; the journal to undo")
+	fmt.Println("              the changes is written to the directory")
+	fmt.Println("   --match    how photos already in the target are recognized: by camera")
+	fmt.Println("              and timestamp (metadata), by SHA-256 (content) or by both")
+	fmt.Println("              (default), which reports metadata matches with a different")
+	fmt.Println("              content as conflicts")
+	fmt.Println("   --dry-run  print the planned changes without touching the filesystem")
+	fmt.Println("   --format   output format of --dry-run, text (default) or json")
 	fmt.Println()
 }
 
+// Matching strategies of the filter operation.
+const (
+	matchBoth     = "both"
+	matchMetadata = "metadata"
+	matchContent  = "content"
+)
+
+// Classes of the photos to be filtered.
+const (
+	classNew       = "new"
+	classDuplicate = "already imported"
+	classConflict  = "conflict"
+	classNoExif    = "no Exif"
+)
+
+// Directories where the filter operation puts the photos, according to
+// their class.
+const (
+	duplicatesDirName = "AlreadyImported"
+	conflictsDirName  = "Conflicts"
+	noExifDirName     = "NoExif"
+	newDirName        = "ToBeImported"
+)
+
+// targetIndex indexes the photos of a target by metadata and content hash.
+type targetIndex struct {
+	byHash    map[string]cache.Photo
+	byContent map[string]cache.Photo
+}
+
+func newTargetIndex(myCache *cache.Cache) *targetIndex {
+	index := &targetIndex{
+		byHash:    make(map[string]cache.Photo),
+		byContent: make(map[string]cache.Photo),
+	}
+	for _, targetPhoto := range myCache.Photos {
+		index.add(targetPhoto)
+	}
+	return index
+}
+
+// add adds a photo to the index, e.g. one just filtered as new.
+func (index *targetIndex) add(targetPhoto cache.Photo) {
+	index.byHash[targetPhoto.Hash] = targetPhoto
+	if targetPhoto.SHA256 != "" {
+		index.byContent[targetPhoto.SHA256] = targetPhoto
+	}
+}
+
+// classify tells whether a photo is new, already in the target, in
+// conflict with a photo of the target (same metadata, different content)
+// or without Exif, according to the matching strategy. The matching
+// photo of the target, if any, is returned as well.
+func (index *targetIndex) classify(photo *cache.Photo, match string) (string, *cache.Photo) {
+	if targetPhoto, exists := index.byContent[photo.SHA256]; exists && match != matchMetadata && photo.SHA256 != "" {
+		// Identical files are duplicates, with or without Exif
+		return classDuplicate, &targetPhoto
+	}
+	if !photo.HasExif() {
+		return classNoExif, nil
+	}
+	targetPhoto, exists := index.byHash[photo.Hash]
+	if !exists || match == matchContent {
+		// With --match content, same metadata and different content
+		// means that it's a new photo
+		return classNew, nil
+	}
+	if match == matchBoth && targetPhoto.SHA256 != "" && targetPhoto.SHA256 != photo.SHA256 {
+		return classConflict, &targetPhoto
+	}
+	return classDuplicate, &targetPhoto
+}
+
+// filterResult summarizes the outcome of filtering a directory.
+type filterResult struct {
+	NewDir     string
+	New        []cache.Photo
+	Duplicates int
+	Conflicts  int
+	NoExif     int
+}
+
+// Print prints a summary of the filter outcome.
+func (r *filterResult) Print() {
+	fmt.Printf("%d new, %d already imported, %d conflicts, %d without Exif\n", len(r.New), r.Duplicates, r.Conflicts, r.NoExif)
+}
+
 // Filter analyzes the photos in the current local directory, puts
 // these that are already present in the target in the "Trash" directory
 // and reorganizes the new ones in daily folders.
 func Filter(conf *config.Config, target *config.Target) {
-	var localDir string
-	if len(os.Args) == 4 {
-		localDir = os.Args[3]
+	args := mustParseArgs(ShowHelpFilter, []string{"dry-run", "remote"}, []string{"format", "match"})
+	localDir := args.arg(1, ".")
+	format := args.choice("format", "text", "json")
+	match := args.choice("match", matchBoth, matchMetadata, matchContent)
+	dryRun := args.flag("dry-run")
+	if args.flag("remote") {
+		sshFilter(conf, target, args.arg(1, ""), match, dryRun, format)
+		return
+	}
+	ops, done := fileOperations(dryRun, format)
+	defer done()
+	et := exiftool.Create(conf.Perl, conf.Workers)
+	defer et.Close()
+	// A dry run doesn't update the cache, whose messages would also
+	// be mixed with the JSON plan
+	var myCache *cache.Cache
+	if dryRun {
+		myCache = loadCacheAsIs(conf, target)
 	} else {
-		localDir = "."
+		myCache = loadLocalCache(conf, target)
+	}
+	result := filterDir(conf, target, myCache, localDir, match, ops, et, !dryRun)
+	if !dryRun {
+		result.Print()
+	}
+}
+
+// sshFilter filters a directory of an SSH target on the remote system,
+// against the collection there, so that the photos aren't transferred.
+func sshFilter(conf *config.Config, target *config.Target, remoteDir string, match string, dryRun bool, format string) {
+	if target.TargetType != "ssh" {
+		log.Fatal("--remote requires an SSH target")
+	}
+	if remoteDir == "" {
+		fmt.Println("--remote requires the directory on the SSH target")
+		ShowHelpFilter()
+		os.Exit(1)
+	}
+	// Makes sure that the remote cache is up to date, unless it's a dry
+	// run, which mustn't change anything
+	if !dryRun {
+		loadLocalCache(conf, target)
+	}
+	remoteArgs := []string{remoteDir, "--match", match, "--format", format}
+	if dryRun {
+		remoteArgs = append(remoteArgs, "--dry-run")
+	}
+	// The cache isn't changed by filtering, and the JSON plan of
+	// --dry-run must reach the standard output untouched
+	sshExec(conf, target, "localfilter", false, remoteArgs...)
+}
+
+// LocalFilter filters a directory of an SSH target on the remote system.
+// The cache of the target must be up to date, since it's not updated.
+func LocalFilter(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpFilter, []string{"dry-run"}, []string{"format", "match"})
+	dir := args.arg(1, "")
+	format := args.choice("format", "text", "json")
+	match := args.choice("match", matchBoth, matchMetadata, matchContent)
+	dryRun := args.flag("dry-run")
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		log.Fatal("Not a directory: " + dir)
+	}
+	myCache, err := cache.Load(conf, target)
+	if err != nil {
+		log.Fatal("Error while loading the cache: " + err.Error())
+	}
+	ops, done := fileOperationsIn(dir, target, dryRun, format)
+	defer done()
+	et := exiftool.Create(target.Perl, conf.Workers)
+	defer et.Close()
+	result := filterDir(conf, target, myCache, dir, match, ops, et, false)
+	if !dryRun {
+		result.Print()
 	}
-	et := exiftool.Create(conf.Perl)
-	duplicatesDir := utils.EnsureDir(filepath.Join(localDir, "AlreadyImported"))
-	noExifDir := utils.EnsureDir(filepath.Join(localDir, "NoExif"))
-	newDir := utils.EnsureDir(filepath.Join(localDir, "ToBeImported"))
-	myCache := loadLocalCache(conf, target)
+}
+
+// filterDir analyzes the photos in a local directory and filters them
+// against the cache of the target.
+func filterDir(conf *config.Config, target *config.Target, targetCache *cache.Cache, localDir string, match string, ops fileops.FileOps, et *exiftool.Exiftool, verbose bool) *filterResult {
 	localCache := cache.Create(target)
-	localCache.AnalyzeDir(localDir, conf.Workers, et, target.Ignore)
-	// Create an hash map of the target cache
-	hashMap := make(map[string]cache.Photo)
-	for _, targetPhoto := range myCache.Photos {
-		hashMap[targetPhoto.Hash] = targetPhoto
+	localCache.AnalyzeDir(localDir, conf.Workers, et, target)
+	return filterPhotos(target, newTargetIndex(targetCache), localDir, localCache.Photos, match, ops, et, verbose)
+}
+
+// filterPhotos moves the photos that are already present in the target
+// to the AlreadyImported directory of localDir, these whose metadata
+// matches a photo of the target with a different content to Conflicts,
+// these without Exif to NoExif, and renames and moves the new ones to
+// the daily folders of ToBeImported.
+func filterPhotos(target *config.Target, index *targetIndex, localDir string, photos []cache.Photo, match string, ops fileops.FileOps, et *exiftool.Exiftool, verbose bool) *filterResult {
+	duplicatesDir := filepath.Join(localDir, duplicatesDirName)
+	conflictsDir := filepath.Join(localDir, conflictsDirName)
+	noExifDir := filepath.Join(localDir, noExifDirName)
+	newDir := filepath.Join(localDir, newDirName)
+	for _, dir := range []string{duplicatesDir, conflictsDir, noExifDir, newDir} {
+		ops.MkdirAll(dir)
 	}
+	result := &filterResult{NewDir: newDir}
+	renameTemplate := target.GetRenameTemplate()
+	folderTemplate := target.GetFolderTemplate()
+	counter := 0
 	// I've loaded both caches, now I should find
 	// photos that are on localCache but NOT on myCache
-	for _, localPhoto := range localCache.Photos {
-		fmt.Printf("Filtering %s\n", localPhoto.Path)
-		localPhoto.HeicToJPEG(et)
-		if !localPhoto.HasExif() {
-			newPath := filepath.Join(noExifDir, filepath.Base(localPhoto.Path))
-			err := os.Rename(localPhoto.Path, newPath)
+	for _, localPhoto := range photos {
+		if verbose {
+			fmt.Printf("Filtering %s\n", localPhoto.Path)
+		}
+		localPhoto.HeicToJPEG(et, target, ops)
+		class, targetPhoto := index.classify(&localPhoto, match)
+		switch class {
+		case classDuplicate:
+			log.Printf("Photo already exists in the target:\n  (%s) %s\n  (%s) %s\n", localPhoto.Hash, localPhoto.Path, targetPhoto.Hash, targetPhoto.Path)
+			err := localPhoto.MoveTo(duplicatesDir, ops)
 			if err != nil {
-				log.Printf("Warning: unable to move photo %s to %s: %s\n", localPhoto.Path, newPath, err.Error())
+				log.Printf("Warning: unable to move photo %s to %s\n", localPhoto.Path, duplicatesDir)
 			}
-		} else {
-			targetPhoto, exists := hashMap[localPhoto.Hash]
-			if exists {
-				log.Printf("Photo already exists in the target:\n  (%s) %s\n  (%s) %s\n", localPhoto.Hash, localPhoto.Path, targetPhoto.Hash, targetPhoto.Path)
-				newPath := filepath.Join(duplicatesDir, filepath.Base(localPhoto.Path))
-				err := os.Rename(localPhoto.Path, newPath)
-				if err != nil {
-					log.Printf("Warning: unable to move photo %s to %s\n", localPhoto.Path, newPath)
-				}
-			} else {
-				// Rename the JPEG file according to its Exif timestamp
-				err := localPhoto.RenameToExif()
-				if err != nil {
-					log.Printf("Warning: unable to rename photo %s according to Exif: %s\n", localPhoto.Path, err.Error())
-					continue
-				}
-				// Ensure that the daily directory yyyy-mm-dd exists
-				t := time.Unix(localPhoto.Timestamp, 0)
-				dailyDir := filepath.Join(newDir, t.Format("2006-01-02"))
-				if _, err := os.Stat(dailyDir); os.IsNotExist(err) {
-					os.Mkdir(dailyDir, 0755)
-				}
-				newPath := filepath.Join(dailyDir, filepath.Base(localPhoto.Path))
-				err = os.Rename(localPhoto.Path, newPath)
-				if err != nil {
-					log.Printf("Warning: unable to move photo %s to %s\n", localPhoto.Path, newPath)
-				}
+			result.Duplicates++
+		case classConflict:
+			log.Printf("Possible conflict, same metadata but different content:\n  (%s) %s\n  (%s) %s\n", localPhoto.SHA256, localPhoto.Path, targetPhoto.SHA256, targetPhoto.Path)
+			err := localPhoto.MoveTo(conflictsDir, ops)
+			if err != nil {
+				log.Printf("Warning: unable to move photo %s to %s\n", localPhoto.Path, conflictsDir)
+			}
+			result.Conflicts++
+		case classNoExif:
+			err := localPhoto.MoveTo(noExifDir, ops)
+			if err != nil {
+				log.Printf("Warning: unable to move photo %s to %s: %s\n", localPhoto.Path, noExifDir, err.Error())
+			}
+			result.NoExif++
+		default:
+			// The daily directory is computed before renaming the
+			// file, so that the template can use the original name
+			counter++
+			dailyDir := filepath.Join(newDir, folderTemplate.Format(localPhoto.NamingFields(counter)))
+			// Rename the JPEG file according to its Exif timestamp
+			err := localPhoto.RenameToExif(renameTemplate, counter, ops)
+			if err != nil {
+				log.Printf("Warning: unable to rename photo %s according to Exif: %s\n", localPhoto.Path, err.Error())
+				continue
+			}
+			// Ensure that the daily directory exists
+			ops.MkdirAll(dailyDir)
+			err = localPhoto.MoveTo(dailyDir, ops)
+			if err != nil {
+				log.Printf("Warning: unable to move photo %s to %s\n", localPhoto.Path, dailyDir)
+				continue
 			}
+			result.New = append(result.New, localPhoto)
 		}
 	}
+	return result
 }
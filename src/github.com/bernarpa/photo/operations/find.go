@@ -0,0 +1,323 @@
+package operations
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+)
+
+// ShowHelpFind prints the help for the find operation.
+func ShowHelpFind() {
+	fmt.Println()
+	fmt.Println("Usage: photo find <TARGET> [--from DATE] [--to DATE] [--camera PATTERN] [--path PATTERN] [--regex]")
+	fmt.Println("                  [--type TYPE] [--min-size SIZE] [--max-size SIZE] [--no-exif] [--bbox BOX]")
+	fmt.Println("                  [--sort date|path|size|camera] [--reverse] [--limit N] [--format paths|table|json|csv]")
+	fmt.Println()
+	fmt.Println("   TARGET      one of the targets defined in config.json")
+	fmt.Println("   --from      first day (YYYY-MM-DD) of the capture time")
+	fmt.Println("   --to        last day (YYYY-MM-DD) of the capture time")
+	fmt.Println("   --camera    camera, as a glob pattern (e.g. 'Canon*') matching the whole name")
+	fmt.Println("   --path      path, as a glob pattern (e.g. '*/2021-07-*') matching the whole path")
+	fmt.Println("   --regex     --camera and --path are regular expressions matching any part")
+	fmt.Println("   --type      photo, video or a file extension (e.g. jpg), can be repeated")
+	fmt.Println("   --min-size  minimum file size, e.g. 500K, 2M or 1G")
+	fmt.Println("   --max-size  maximum file size")
+	fmt.Println("   --no-exif   only the files without camera or timestamp")
+	fmt.Println("   --bbox      GPS bounding box MIN_LAT,MIN_LON,MAX_LAT,MAX_LON, which excludes")
+	fmt.Println("               the files without GPS position")
+	fmt.Println("   --sort      sort order, date (default), path, size or camera")
+	fmt.Println("   --reverse   reverse the sort order")
+	fmt.Println("   --limit     print at most N files")
+	fmt.Println("   --format    output format, paths (default), table, json or csv")
+	fmt.Println()
+}
+
+// photoFilter is a condition on the cache entries.
+type photoFilter func(photo *cache.Photo) bool
+
+// globToRegexp converts a glob pattern, where * matches any sequence of
+// characters and ? any single character, to a regular expression that
+// matches the whole string.
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// compilePattern compiles a --camera or --path pattern, case insensitive.
+func compilePattern(pattern string, isRegex bool) (*regexp.Regexp, error) {
+	if !isRegex {
+		pattern = globToRegexp(pattern)
+	}
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// parseSize parses a file size such as 500K, 2M, 1.5G or 1024.
+func parseSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "B")
+	multiplier := 1.0
+	if s != "" {
+		if i := strings.IndexByte("KMGT", s[len(s)-1]); i >= 0 {
+			for ; i >= 0; i-- {
+				multiplier *= 1024
+			}
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 500K, 2M or 1G", value)
+	}
+	return int64(n * multiplier), nil
+}
+
+// parseBBox parses a GPS bounding box MIN_LAT,MIN_LON,MAX_LAT,MAX_LON.
+func parseBBox(value string) ([4]float64, error) {
+	var box [4]float64
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return box, fmt.Errorf("invalid bounding box %q, expected MIN_LAT,MIN_LON,MAX_LAT,MAX_LON", value)
+	}
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return box, fmt.Errorf("invalid bounding box %q: %s", value, err.Error())
+		}
+		box[i] = f
+	}
+	return box, nil
+}
+
+// findFilters builds the filters specified on the command line.
+func findFilters(args *cmdArgs) ([]photoFilter, error) {
+	var filters []photoFilter
+	from, to := args.value("from", ""), args.value("to", "")
+	for _, day := range []string{from, to} {
+		if _, err := time.Parse("2006-01-02", day); day != "" && err != nil {
+			return nil, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", day)
+		}
+	}
+	if from != "" || to != "" {
+		filters = append(filters, func(photo *cache.Photo) bool {
+			return photo.Timestamp != 0 && inDateRange(photo, from, to)
+		})
+	}
+	isRegex := args.flag("regex")
+	if pattern := args.value("camera", ""); pattern != "" {
+		re, err := compilePattern(pattern, isRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --camera: %s", err.Error())
+		}
+		filters = append(filters, func(photo *cache.Photo) bool {
+			return re.MatchString(photo.Camera)
+		})
+	}
+	if pattern := args.value("path", ""); pattern != "" {
+		re, err := compilePattern(pattern, isRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --path: %s", err.Error())
+		}
+		filters = append(filters, func(photo *cache.Photo) bool {
+			return re.MatchString(photo.Path)
+		})
+	}
+	if types := args.values("type"); len(types) > 0 {
+		filters = append(filters, func(photo *cache.Photo) bool {
+			ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(photo.Path)), ".")
+			for _, t := range types {
+				t = strings.TrimPrefix(strings.ToLower(t), ".")
+				if t == photo.Kind() || t == ext {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	for _, bound := range []string{"min-size", "max-size"} {
+		value := args.value(bound, "")
+		if value == "" {
+			continue
+		}
+		size, err := parseSize(value)
+		if err != nil {
+			return nil, err
+		}
+		isMin := bound == "min-size"
+		filters = append(filters, func(photo *cache.Photo) bool {
+			if isMin {
+				return photo.Size >= size
+			}
+			return photo.Size <= size
+		})
+	}
+	if args.flag("no-exif") {
+		filters = append(filters, func(photo *cache.Photo) bool {
+			return !photo.HasExif()
+		})
+	}
+	if value := args.value("bbox", ""); value != "" {
+		box, err := parseBBox(value)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, func(photo *cache.Photo) bool {
+			l := photo.Location
+			return l != nil && l.Latitude >= box[0] && l.Longitude >= box[1] && l.Latitude <= box[2] && l.Longitude <= box[3]
+		})
+	}
+	return filters, nil
+}
+
+// sortPhotos sorts the photos according to the --sort option. The path
+// breaks ties, so that the order is always the same.
+func sortPhotos(photos []cache.Photo, by string, reverse bool) {
+	less := func(a, b *cache.Photo) bool {
+		switch by {
+		case "date":
+			if a.Timestamp != b.Timestamp {
+				return a.Timestamp < b.Timestamp
+			}
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "camera":
+			if a.Camera != b.Camera {
+				return a.Camera < b.Camera
+			}
+		}
+		return a.Path < b.Path
+	}
+	sort.Slice(photos, func(i, j int) bool {
+		if reverse {
+			return less(&photos[j], &photos[i])
+		}
+		return less(&photos[i], &photos[j])
+	})
+}
+
+// photoTime formats the capture time of a photo, or returns "" if unknown.
+func photoTime(photo *cache.Photo) string {
+	if photo.Timestamp == 0 {
+		return ""
+	}
+	return photo.Time().Format("2006-01-02 15:04:05 -07:00")
+}
+
+func printFindCSV(photos []cache.Photo) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"path", "time", "camera", "kind", "size", "width", "height", "latitude", "longitude", "hash", "sha256"})
+	for i := range photos {
+		photo := &photos[i]
+		var lat, lon string
+		if photo.Location != nil {
+			lat = strconv.FormatFloat(photo.Location.Latitude, 'f', 6, 64)
+			lon = strconv.FormatFloat(photo.Location.Longitude, 'f', 6, 64)
+		}
+		w.Write([]string{photo.Path, photoTime(photo), photo.Camera, photo.Kind(), strconv.FormatInt(photo.Size, 10),
+			formatInt(int64(photo.Width)), formatInt(int64(photo.Height)), lat, lon, photo.Hash, photo.SHA256})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func printFindTable(photos []cache.Photo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tCAMERA\tSIZE\tPATH")
+	for i := range photos {
+		photo := &photos[i]
+		t := photoTime(photo)
+		if t == "" {
+			t = "-"
+		}
+		camera := photo.Camera
+		if camera == "" {
+			camera = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t, camera, formatBytes(photo.Size), photo.Path)
+	}
+	w.Flush()
+	fmt.Printf("%d files\n", len(photos))
+}
+
+// Find prints the photos of the target cache that match the filters
+// specified on the command line.
+func Find(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpFind, []string{"regex", "no-exif", "reverse"},
+		[]string{"from", "to", "camera", "path", "type", "min-size", "max-size", "bbox", "sort", "limit", "format"})
+	format := args.choice("format", "paths", "table", "json", "csv")
+	sortBy := args.choice("sort", "date", "path", "size", "camera")
+	limit, err := strconv.Atoi(args.value("limit", "0"))
+	if err != nil || limit < 0 {
+		log.Fatal("Invalid --limit: " + args.value("limit", ""))
+	}
+	filters, err := findFilters(args)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	myCache := loadLocalCache(conf, target)
+	photos := []cache.Photo{}
+	for i := range myCache.Photos {
+		photo := &myCache.Photos[i]
+		if photo.Ignored {
+			continue
+		}
+		matches := true
+		for _, filter := range filters {
+			if !filter(photo) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			photos = append(photos, *photo)
+		}
+	}
+	sortPhotos(photos, sortBy, args.flag("reverse"))
+	if limit > 0 && len(photos) > limit {
+		photos = photos[:limit]
+	}
+	switch format {
+	case "json":
+		out, err := json.MarshalIndent(photos, "", "  ")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Println(string(out))
+	case "csv":
+		err := printFindCSV(photos)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	case "table":
+		printFindTable(photos)
+	default:
+		for _, photo := range photos {
+			fmt.Println(photo.Path)
+		}
+	}
+}
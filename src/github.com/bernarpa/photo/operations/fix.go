@@ -3,7 +3,6 @@ package operations
 import (
 	"fmt"
 	"log"
-	"os"
 
 	"github.com/bernarpa/photo/cache"
 	"github.com/bernarpa/photo/config"
@@ -13,32 +12,42 @@ import (
 // ShowHelpFix prints the help for the info operation.
 func ShowHelpFix() {
 	fmt.Println()
-	fmt.Println("Usage: photo fix [directory]")
+	fmt.Println("Usage: photo fix [directory] [--dry-run] [--format text|json]")
 	fmt.Println()
 	fmt.Println("   directory  local directory with the photos to be fixed")
+	fmt.Println("   --dry-run  print the planned changes without touching the filesystem")
+	fmt.Println("   --format   output format of --dry-run, text (default) or json")
 	fmt.Println()
 }
 
 // Fix renames the photo in the specified directory according to
 // their Exif timestamps. HEIC photos are converted to JPEG.
 func Fix(conf *config.Config, target *config.Target) {
-	var localDir string
-	if len(os.Args) == 3 {
-		localDir = os.Args[2]
-	} else {
-		localDir = "."
-	}
+	args := mustParseArgs(ShowHelpFix, []string{"dry-run"}, []string{"format"})
+	localDir := args.arg(0, ".")
+	format := args.choice("format", "text", "json")
+	dryRun := args.flag("dry-run")
+	ops, done := fileOperations(dryRun, format)
+	defer done()
 	localCache := cache.Create(target)
-	et := exiftool.Create(conf.Perl)
-	localCache.AnalyzeDir(localDir, conf.Workers, et, []string{})
+	et := exiftool.Create(conf.Perl, conf.Workers)
+	defer et.Close()
+	localCache.AnalyzeDir(localDir, conf.Workers, et, nil)
+	renameTemplate := target.GetRenameTemplate()
+	counter := 0
 	for _, localPhoto := range localCache.Photos {
-		fmt.Printf("Fixing %s\n", localPhoto.Path)
-		localPhoto.HeicToJPEG(et)
+		if !dryRun {
+			fmt.Printf("Fixing %s\n", localPhoto.Path)
+		}
+		localPhoto.HeicToJPEG(et, nil, ops)
 		if localPhoto.Timestamp == 0 {
-			fmt.Println("no timestamp")
+			if !dryRun {
+				fmt.Println("no timestamp")
+			}
 			continue
 		}
-		err := localPhoto.RenameToExif()
+		counter++
+		err := localPhoto.RenameToExif(renameTemplate, counter, ops)
 		if err != nil {
 			log.Printf("Warning: unable to rename photo %s according to Exif: %s\n", localPhoto.Path, err.Error())
 			continue
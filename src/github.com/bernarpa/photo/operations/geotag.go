@@ -0,0 +1,174 @@
+package operations
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+	"github.com/bernarpa/photo/exiftool"
+	"github.com/bernarpa/photo/gpx"
+)
+
+// ShowHelpGeotag prints the help for the geotag operation.
+func ShowHelpGeotag() {
+	fmt.Println()
+	fmt.Println("Usage: photo geotag [directory] --gpx FILE [--gpx FILE]... [--max-gap DURATION] [--offset OFFSET]")
+	fmt.Println("                    [--camera CAMERA] [--overwrite] [--target TARGET [--update]] [--dry-run] [--format text|json]")
+	fmt.Println()
+	fmt.Println("   directory    local directory with the photos to be geotagged")
+	fmt.Println("   --gpx        GPX track, can be repeated")
+	fmt.Println("   --max-gap    maximum time between two track points, or between a photo")
+	fmt.Println("                and the start or the end of the track (default: 30m)")
+	fmt.Println("   --offset     correction added to the capture time of the photos when")
+	fmt.Println("                matching them with the track, e.g. +1h or -00:05:30")
+	fmt.Println("   --camera     geotag only the photos of CAMERA, as shown by photo info")
+	fmt.Println("   --overwrite  geotag also the photos that already have a GPS position")
+	fmt.Println("   --target     target whose time zones are used and, if it's local, whose")
+	fmt.Println("                cache gets the new positions of the photos of its collections")
+	fmt.Println("   --update     update the cache of TARGET afterwards, which reads again")
+	fmt.Println("                the modified files instead of waiting for the next update")
+	fmt.Println("   --dry-run    print the planned changes without touching the filesystem")
+	fmt.Println("   --format     output format of --dry-run, text (default) or json")
+	fmt.Println()
+}
+
+// Geotag writes the GPS position of the photos in the specified directory,
+// interpolated from GPX tracks according to their capture time.
+func Geotag(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpGeotag, []string{"overwrite", "update", "dry-run"}, []string{"gpx", "max-gap", "offset", "camera", "target", "format"})
+	localDir := args.arg(0, ".")
+	format := args.choice("format", "text", "json")
+	dryRun := args.flag("dry-run")
+	// The JSON plan must be the only output
+	verbose := !dryRun || format == "text"
+	if len(args.values("gpx")) == 0 {
+		ShowHelpGeotag()
+		return
+	}
+	if name := args.value("target", ""); name != "" {
+		target = conf.GetTarget(name)
+		if target == nil {
+			log.Fatal("Target not found: " + name)
+		}
+	} else if args.flag("update") {
+		log.Fatal("--update requires --target")
+	}
+	maxGap, err := time.ParseDuration(args.value("max-gap", "30m"))
+	if err != nil {
+		log.Fatal("Invalid --max-gap: " + err.Error())
+	}
+	var offset time.Duration
+	if value := args.value("offset", ""); value != "" {
+		offset, err = parseOffset(value)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	camera := args.value("camera", "")
+	track, err := gpx.Load(args.values("gpx")...)
+	if err != nil {
+		log.Fatal("GPX loading error: " + err.Error())
+	}
+	if len(track.Points) == 0 {
+		log.Fatal("The GPX files have no track points with time")
+	}
+	et := exiftool.Create(conf.Perl, conf.Workers)
+	defer et.Close()
+	localCache := cache.Create(target)
+	err = localCache.AnalyzeDir(localDir, conf.Workers, et, target)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	ops, done := fileOperations(dryRun, format)
+	tagged, located, noTime, outOfTrack, failed := 0, 0, 0, 0, 0
+	locations := make(map[string]cache.Location)
+	for i := range localCache.Photos {
+		photo := &localCache.Photos[i]
+		if photo.Ignored || (camera != "" && photo.Camera != camera) {
+			continue
+		}
+		if photo.Location != nil && !args.flag("overwrite") {
+			located++
+			continue
+		}
+		if photo.Timestamp == 0 {
+			noTime++
+			continue
+		}
+		point, ok := track.Position(time.Unix(photo.Timestamp, 0).Add(offset), maxGap)
+		if !ok {
+			outOfTrack++
+			continue
+		}
+		err := ops.Modify(photo.Path, func(path string) error {
+			return et.SetLocation(path, point.Latitude, point.Longitude, point.Elevation, point.HasElevation)
+		})
+		if err != nil {
+			log.Printf("Warning: unable to geotag %s: %s\n", photo.Path, err.Error())
+			failed++
+			continue
+		}
+		tagged++
+		location := cache.Location{Latitude: point.Latitude, Longitude: point.Longitude}
+		if point.HasElevation {
+			elevation := point.Elevation
+			location.Altitude = &elevation
+		}
+		if path, err := filepath.Abs(photo.Path); err == nil {
+			locations[path] = location
+		}
+		if verbose {
+			fmt.Printf("%s: %.6f, %.6f\n", photo.Path, point.Latitude, point.Longitude)
+		}
+	}
+	done()
+	if verbose {
+		fmt.Printf("%d geotagged, %d already with GPS position, %d without timestamp, %d outside of the tracks, %d errors\n", tagged, located, noTime, outOfTrack, failed)
+	}
+	if dryRun || args.value("target", "") == "" {
+		return
+	}
+	if args.flag("update") {
+		Update(conf, target)
+	} else if target.TargetType == "local" {
+		setCachedLocations(conf, target, locations)
+	}
+}
+
+// setCachedLocations sets the GPS position of the geotagged photos in the
+// cache of a local target, so that they can be queried without a full
+// update. Their size and modification time aren't changed, so that the
+// next update reads them again and computes the new hashes.
+func setCachedLocations(conf *config.Config, target *config.Target, locations map[string]cache.Location) {
+	if len(locations) == 0 {
+		return
+	}
+	myCache, err := cache.Load(conf, target)
+	if err != nil {
+		log.Printf("Warning: the cache of %s can't be loaded, run photo update: %s\n", target.Name, err.Error())
+		return
+	}
+	changed := 0
+	for i := range myCache.Photos {
+		photo := &myCache.Photos[i]
+		path, err := filepath.Abs(photo.Path)
+		if err != nil {
+			continue
+		}
+		if location, ok := locations[path]; ok {
+			photo.Location = &location
+			changed++
+		}
+	}
+	if changed == 0 {
+		return
+	}
+	err = myCache.Save(target.GetLocalCachePath())
+	if err != nil {
+		log.Fatal("Cache file writing error: " + err.Error())
+	}
+	log.Printf("%d positions recorded in the cache of %s\n", changed, target.Name)
+}
@@ -1,57 +1,58 @@
 package operations
 
 import (
-	"compress/gzip"
-	"encoding/json"
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/bernarpa/photo/cache"
 	"github.com/bernarpa/photo/config"
 	"github.com/bernarpa/photo/exiftool"
+	"github.com/bernarpa/photo/fileops"
 )
 
 // ShowHelpIgnore prints the help for the info operation.
 func ShowHelpIgnore() {
 	fmt.Println()
-	fmt.Println("Usage: photo ignore [directory]")
+	fmt.Println("Usage: photo ignore [directory] [--dry-run] [--format text|json]")
 	fmt.Println()
 	fmt.Println("   directory       directory containing the files to ignore (recursive),")
 	fmt.Println("                   by default it's the current directory")
+	fmt.Println("   --dry-run       print the files that would be ignored without")
+	fmt.Println("                   creating the photoignore file")
+	fmt.Println("   --format        output format of --dry-run, text (default) or json")
 	fmt.Println()
 }
 
 // Ignore creates a photoignore file with the files in the current directory.
 // It process all files, recursively.
 func Ignore(conf *config.Config, target *config.Target) {
-	var targetDir string
-	if len(os.Args) == 3 {
-		targetDir = os.Args[2]
-	} else {
-		targetDir = "."
-	}
-	et := exiftool.Create(conf.Perl)
+	args := mustParseArgs(ShowHelpIgnore, []string{"dry-run"}, []string{"format"})
+	targetDir := args.arg(0, ".")
+	format := args.choice("format", "text", "json")
+	et := exiftool.Create(conf.Perl, conf.Workers)
+	defer et.Close()
 	log.Printf("exiftool created: %s\n", et.Perl)
 	myCache := cache.Create(target)
-	err := myCache.AnalyzeDir(targetDir, conf.Workers, et, []string{})
+	err := myCache.AnalyzeDir(targetDir, conf.Workers, et, nil)
 	if err != nil {
 		log.Fatal("Cache update failure: " + err.Error())
 	}
-	jsonContent, err := json.Marshal(myCache)
 	now := time.Now()
 	nowStr := now.Format("2006-01-02_15-04-05")
 	photoIgnoreFileName := fmt.Sprintf("photoignore_%s.json.gz", nowStr)
 	photoIgnorePath := filepath.Join(targetDir, photoIgnoreFileName)
-	f, err := os.Create(photoIgnorePath)
-	if err != nil {
-		log.Fatal("Photoignore file creation error: " + err.Error())
+	if args.flag("dry-run") {
+		plan := fileops.NewDryRun()
+		for _, photo := range myCache.Photos {
+			plan.Add(fileops.OpIgnore, photo.Path, "")
+		}
+		plan.Add(fileops.OpCreate, photoIgnorePath, "")
+		plan.Print(format)
+		return
 	}
-	w := gzip.NewWriter(f)
-	defer w.Close()
-	_, err = w.Write(jsonContent)
+	err = myCache.Save(photoIgnorePath)
 	if err != nil {
 		log.Fatal("Photoignore file writing error: " + err.Error())
 	}
@@ -0,0 +1,284 @@
+package operations
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+	"github.com/bernarpa/photo/exiftool"
+	"github.com/bernarpa/photo/fileops"
+	"github.com/bernarpa/photo/ssh"
+	"github.com/bernarpa/photo/utils"
+)
+
+// statBatchSize is the number of files checked by each remote localstat.
+const statBatchSize = 50
+
+// ShowHelpImport prints the help for the import operation.
+func ShowHelpImport() {
+	fmt.Println()
+	fmt.Println("Usage: photo import <TARGET> [directory] [--match both|metadata|content] [--archive DIR]")
+	fmt.Println()
+	fmt.Println("   TARGET     one of the targets defined in config.json")
+	fmt.Println("   directory  local directory with the photos to be imported")
+	fmt.Println("   --match    how photos already in the target are recognized, see filter")
+	fmt.Println("   --archive  move the imported photos to DIR instead of moving them to the")
+	fmt.Println("              trash of the journal (see photo undo --purge)")
+	fmt.Println()
+}
+
+// fileStat is the size, modification time and SHA-256 hash of a file,
+// as printed by localstat.
+type fileStat struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	SHA256  string `json:"sha256"`
+	Error   string `json:"error,omitempty"`
+}
+
+func statFile(path string) fileStat {
+	stat := fileStat{Path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		stat.Error = err.Error()
+		return stat
+	}
+	stat.Size = info.Size()
+	stat.ModTime = info.ModTime().UnixNano()
+	stat.SHA256, err = utils.SHA256(path)
+	if err != nil {
+		stat.Error = err.Error()
+	}
+	return stat
+}
+
+// importedFile is a new photo copied to the target.
+type importedFile struct {
+	photo  cache.Photo
+	source string
+	rel    string
+	dest   fileStat
+}
+
+// LocalStat prints, one JSON object per line, the size, modification time
+// and SHA-256 hash of the files specified on the command line. It's meant
+// to be run on SSH targets, to check the files copied there.
+func LocalStat(conf *config.Config, target *config.Target) {
+	for _, path := range os.Args[3:] {
+		line, err := json.Marshal(statFile(path))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Println(string(line))
+	}
+}
+
+// Import filters a local directory like Filter does, then copies the new
+// photos to the import_dir of the target, checks the copies, adds them to
+// the cache and finally deletes (or archives) the local originals.
+func Import(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpImport, nil, []string{"archive", "match"})
+	localDir := args.arg(1, ".")
+	match := args.choice("match", matchBoth, matchMetadata, matchContent)
+	archiveDir := args.value("archive", "")
+	if target.ImportDir == "" {
+		log.Fatal("import_dir is not configured for target " + target.Name)
+	}
+	ops, done := fileOperations(false, "text")
+	defer done()
+	et := exiftool.Create(conf.Perl, conf.Workers)
+	defer et.Close()
+	result := filterDir(conf, target, loadLocalCache(conf, target), localDir, match, ops, et, true)
+	result.Print()
+	var files []importedFile
+	for _, photo := range result.New {
+		rel, err := filepath.Rel(result.NewDir, photo.Path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			log.Printf("Warning: %s is not in %s, it won't be imported\n", photo.Path, result.NewDir)
+			continue
+		}
+		files = append(files, importedFile{photo: photo, source: photo.Path, rel: rel})
+	}
+	if len(files) == 0 {
+		return
+	}
+	var imported []importedFile
+	if target.TargetType == "local" {
+		imported = localImport(target, files, ops)
+	} else if target.TargetType == "ssh" {
+		imported = sshImport(conf, target, files)
+	} else {
+		log.Fatal("Unsupported target type: " + target.TargetType)
+	}
+	// Add the new photos to the cache, without a full update
+	myCache, err := cache.Load(conf, target)
+	if err != nil {
+		log.Fatal("Error while loading the cache: " + err.Error())
+	}
+	for _, file := range imported {
+		photo := file.photo
+		photo.Path = file.dest.Path
+		photo.ModTime = file.dest.ModTime
+		myCache.Photos = append(myCache.Photos, photo)
+	}
+	err = myCache.Save(target.GetLocalCachePath())
+	if err != nil {
+		log.Fatal("Cache file writing error: " + err.Error())
+	}
+	// Only now the local originals can go away, to the trash of the
+	// journal so that they can be restored by undo
+	for _, file := range imported {
+		if archiveDir != "" {
+			photo := file.photo
+			dir := filepath.Join(archiveDir, filepath.Dir(file.rel))
+			err = ops.MkdirAll(dir)
+			if err == nil {
+				err = photo.MoveTo(dir, ops)
+			}
+		} else {
+			err = ops.Remove(file.source)
+		}
+		if err != nil {
+			log.Printf("Warning: unable to remove or archive %s: %s\n", file.source, err.Error())
+		}
+	}
+	fmt.Printf("%d of %d new photos imported into %s\n", len(imported), len(files), target.ImportDir)
+}
+
+// checkCopy compares a copy with its source.
+func checkCopy(source string, dest fileStat) error {
+	if dest.Error != "" {
+		return fmt.Errorf("unable to check the copy: %s", dest.Error)
+	}
+	src := statFile(source)
+	if src.Error != "" {
+		return fmt.Errorf("unable to check the source: %s", src.Error)
+	}
+	if src.Size != dest.Size || src.SHA256 != dest.SHA256 {
+		return fmt.Errorf("the copy differs from the source (size %d/%d, sha256 %s/%s)", src.Size, dest.Size, src.SHA256, dest.SHA256)
+	}
+	return nil
+}
+
+// localImport copies the files to the import_dir of a local target,
+// recording the copies so that undo removes them.
+func localImport(target *config.Target, files []importedFile, ops fileops.FileOps) []importedFile {
+	var imported []importedFile
+	for _, file := range files {
+		dest := filepath.Join(target.ImportDir, file.rel)
+		if _, err := os.Stat(dest); !os.IsNotExist(err) {
+			log.Printf("Warning: %s already exists, %s won't be imported\n", dest, file.source)
+			continue
+		}
+		fmt.Printf("Copying %s to %s\n", file.source, dest)
+		err := ops.MkdirAll(filepath.Dir(dest))
+		if err == nil {
+			err = ops.Copy(file.source, dest)
+		}
+		if err != nil {
+			log.Printf("Warning: unable to copy %s to %s: %s\n", file.source, dest, err.Error())
+			continue
+		}
+		file.dest = statFile(dest)
+		err = checkCopy(file.source, file.dest)
+		if err != nil {
+			log.Printf("Warning: %s: %s\n", dest, err.Error())
+			os.Remove(dest)
+			continue
+		}
+		imported = append(imported, file)
+	}
+	return imported
+}
+
+// remoteStat runs localstat on an SSH target for the specified files.
+func remoteStat(client *ssh.Client, remoteExe string, target *config.Target, paths []string) map[string]fileStat {
+	stats := make(map[string]fileStat)
+	for start := 0; start < len(paths); start += statBatchSize {
+		end := start + statBatchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+		cmd := fmt.Sprintf("%s localstat %s", ssh.Quote(remoteExe), ssh.Quote(target.Name))
+		for _, path := range paths[start:end] {
+			cmd += " " + ssh.Quote(path)
+		}
+		out := ssh.Exec(client, cmd)
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			// Skip the log messages, which are mixed with the output
+			if !bytes.HasPrefix(line, []byte("{")) {
+				continue
+			}
+			var stat fileStat
+			if err := json.Unmarshal(line, &stat); err == nil {
+				stats[stat.Path] = stat
+			}
+		}
+	}
+	return stats
+}
+
+// sshImport copies the files to the import_dir of an SSH target.
+func sshImport(conf *config.Config, target *config.Target, files []importedFile) []importedFile {
+	client, _, err := ssh.Connect(target)
+	if err != nil {
+		log.Fatal("SSH connection error: " + err.Error())
+	}
+	defer client.Close()
+	remoteExe := sshDeploy(conf, target, client, false)
+	importDir := strings.TrimSuffix(target.ImportDir, target.SSHPathSeparator) + target.SSHPathSeparator
+	var dests []string
+	for i := range files {
+		files[i].dest.Path = importDir + strings.ReplaceAll(files[i].rel, conf.PathSeparator, target.SSHPathSeparator)
+		dests = append(dests, files[i].dest.Path)
+	}
+	// Never overwrite the files already on the target
+	existing := remoteStat(client, remoteExe, target, dests)
+	var toCopy []importedFile
+	dirs := make(map[string]bool)
+	for _, file := range files {
+		if stat, exists := existing[file.dest.Path]; exists && stat.Error == "" {
+			log.Printf("Warning: %s already exists, %s won't be imported\n", file.dest.Path, file.source)
+			continue
+		}
+		dirs[file.dest.Path[:strings.LastIndex(file.dest.Path, target.SSHPathSeparator)]] = true
+		toCopy = append(toCopy, file)
+	}
+	for dir := range dirs {
+		ssh.Exec(client, "mkdir -p "+ssh.Quote(dir))
+	}
+	dests = nil
+	for _, file := range toCopy {
+		fmt.Printf("Copying %s to %s\n", file.source, file.dest.Path)
+		ssh.Copy(client, file.source, file.dest.Path)
+		dests = append(dests, file.dest.Path)
+	}
+	// Check the copies
+	copied := remoteStat(client, remoteExe, target, dests)
+	var imported []importedFile
+	for _, file := range toCopy {
+		stat, exists := copied[file.dest.Path]
+		if !exists {
+			stat = fileStat{Path: file.dest.Path, Error: "no answer from localstat"}
+		}
+		err := checkCopy(file.source, stat)
+		if err != nil {
+			log.Printf("Warning: %s: %s\n", file.dest.Path, err.Error())
+			ssh.Exec(client, "rm -f "+ssh.Quote(file.dest.Path))
+			continue
+		}
+		file.dest = stat
+		imported = append(imported, file)
+	}
+	return imported
+}
@@ -1,9 +1,17 @@
 package operations
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/bernarpa/photo/cache"
 	"github.com/bernarpa/photo/config"
 	"github.com/bernarpa/photo/exiftool"
 	"github.com/rwcarlsen/goexif/exif"
@@ -13,29 +21,306 @@ import (
 // ShowHelpInfo prints the help for the info operation.
 func ShowHelpInfo() {
 	fmt.Println()
-	fmt.Println("Usage: photo info [file]")
+	fmt.Println("Usage: photo info <file|directory>... [--format text|json|csv] [--target TARGET [--match both|metadata|content]]")
 	fmt.Println()
 	fmt.Println("   file       photo or video file")
+	fmt.Println("   directory  directory with photos and videos, analyzed recursively")
+	fmt.Println("   --format   output format, text (default), json or csv")
+	fmt.Println("   --target   tell how filter would classify the files with respect to")
+	fmt.Println("              TARGET, whose naming templates are used as well")
+	fmt.Println("   --match    matching strategy of the classification, see filter")
 	fmt.Println()
 }
 
-type infoWalker struct{}
+// infoWalker collects the goexif tags of a photo.
+type infoWalker map[string]string
 
-func (infoWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
-	data, _ := tag.MarshalJSON()
-	fmt.Printf("    %s: %s\n", name, string(data))
+func (w infoWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	w[string(name)] = strings.Trim(tag.String(), `"`)
 	return nil
 }
 
-// Info tries to print the metadata of the photo or video file.
-func Info(conf *config.Config, target *config.Target) {
-	var fileName string
-	if len(os.Args) == 3 {
-		fileName = os.Args[2]
+// infoRecord is the metadata of a file, together with the values
+// that Photo derives from it.
+type infoRecord struct {
+	Path     string            `json:"path"`
+	Photo    *cache.Photo      `json:"photo,omitempty"`
+	Time     string            `json:"time,omitempty"`
+	RenameTo string            `json:"rename_to,omitempty"`
+	Class    string            `json:"class,omitempty"`
+	Matches  string            `json:"matches,omitempty"`
+	Exif     map[string]string `json:"exif,omitempty"`
+	Exiftool map[string]string `json:"exiftool,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// infoFiles expands the directories among the specified paths to the
+// photos and videos that they contain.
+func infoFiles(paths []string) []string {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() && cache.IsSupported(p) {
+				files = append(files, p)
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// analyzeInfo collects the metadata of a file.
+func analyzeInfo(path string, target *config.Target, index *targetIndex, match string, et *exiftool.Exiftool) infoRecord {
+	record := infoRecord{Path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	photo, err := cache.AnalyzePhoto(path, info, et, target)
+	if err != nil {
+		record.Error = err.Error()
 	} else {
+		record.Photo = &photo
+		if photo.Timestamp != 0 {
+			record.Time = photo.Time().Format("2006-01-02 15:04:05 -07:00")
+		}
+		if photo.HasExif() {
+			fields := photo.NamingFields(1)
+			name := target.GetRenameTemplate().Format(fields) + strings.ToLower(filepath.Ext(path))
+			record.RenameTo = filepath.Join(target.GetFolderTemplate().Format(fields), name)
+		}
+		if index != nil {
+			class, targetPhoto := index.classify(&photo, match)
+			record.Class = class
+			if targetPhoto != nil {
+				record.Matches = targetPhoto.Path
+			}
+		}
+	}
+	if f, err := os.Open(path); err == nil {
+		if x, err := exif.Decode(f); err == nil {
+			walker := make(infoWalker)
+			x.Walk(walker)
+			record.Exif = walker
+		}
+		f.Close()
+	}
+	tags, err := et.Tags(path)
+	if err == nil {
+		record.Exiftool = tags
+	} else if record.Error == "" {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLocation formats a GPS position, e.g. 45.464200, 9.190000, 122 m.
+func formatLocation(location *cache.Location) string {
+	text := fmt.Sprintf("%.6f, %.6f", location.Latitude, location.Longitude)
+	if location.Altitude != nil {
+		text += fmt.Sprintf(", %.0f m", *location.Altitude)
+	}
+	return text
+}
+
+// formatSettings formats the shooting settings of a photo, e.g.
+// 1/250 s, f/2.8, ISO 100.
+func formatSettings(photo *cache.Photo) string {
+	var settings []string
+	if photo.Exposure != "" {
+		settings = append(settings, photo.Exposure+" s")
+	}
+	if photo.FNumber != 0 {
+		settings = append(settings, fmt.Sprintf("f/%g", photo.FNumber))
+	}
+	if photo.ISO != 0 {
+		settings = append(settings, fmt.Sprintf("ISO %d", photo.ISO))
+	}
+	return strings.Join(settings, ", ")
+}
+
+func printInfoText(records []infoRecord) {
+	for _, r := range records {
+		fmt.Println(r.Path)
+		if r.Error != "" {
+			fmt.Printf("  Error: %s\n", r.Error)
+		}
+		if r.Photo != nil {
+			fmt.Println("  Photo")
+			if r.Class != "" {
+				fmt.Printf("    Class:       %s\n", r.Class)
+			}
+			if r.Matches != "" {
+				fmt.Printf("    Matches:     %s\n", r.Matches)
+			}
+			fmt.Printf("    Timestamp:   %d %s\n", r.Photo.Timestamp, r.Time)
+			fmt.Printf("    Camera:      %s\n", r.Photo.Camera)
+			if r.Photo.Location != nil {
+				fmt.Printf("    Location:    %s\n", formatLocation(r.Photo.Location))
+			}
+			if r.Photo.Width != 0 {
+				fmt.Printf("    Dimensions:  %dx%d\n", r.Photo.Width, r.Photo.Height)
+			}
+			if r.Photo.Orientation != 0 {
+				fmt.Printf("    Orientation: %d\n", r.Photo.Orientation)
+			}
+			if r.Photo.Lens != "" {
+				fmt.Printf("    Lens:        %s\n", r.Photo.Lens)
+			}
+			if settings := formatSettings(r.Photo); settings != "" {
+				fmt.Printf("    Settings:    %s\n", settings)
+			}
+			if r.Photo.Duration != 0 {
+				fmt.Printf("    Duration:    %g s\n", r.Photo.Duration)
+			}
+			fmt.Printf("    Hash:        %s\n", r.Photo.Hash)
+			fmt.Printf("    SHA-256:     %s\n", r.Photo.SHA256)
+			if r.Photo.SubSec != "" {
+				fmt.Printf("    Sub-second:  %s\n", r.Photo.SubSec)
+			}
+			if r.RenameTo != "" {
+				fmt.Printf("    Rename to:   %s\n", r.RenameTo)
+			} else {
+				fmt.Printf("    Rename to:   - (no Exif, filter moves it to NoExif)\n")
+			}
+		}
+		for _, section := range []struct {
+			title string
+			tags  map[string]string
+		}{{"Exif", r.Exif}, {"ExifTool", r.Exiftool}} {
+			if len(section.tags) == 0 {
+				continue
+			}
+			fmt.Printf("  %s\n", section.title)
+			for _, key := range sortedKeys(section.tags) {
+				fmt.Printf("    %s: %s\n", key, section.tags[key])
+			}
+		}
+	}
+}
+
+// formatInt formats a number for the CSV output, where 0 means missing.
+func formatInt(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// formatFloat formats a number for the CSV output, where 0 means missing.
+func formatFloat(f float64) string {
+	if f == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func printInfoCSV(records []infoRecord) error {
+	// Each tag found in any file becomes a column
+	exifColumns := make(map[string]string)
+	exiftoolColumns := make(map[string]string)
+	for _, r := range records {
+		for key := range r.Exif {
+			exifColumns[key] = ""
+		}
+		for key := range r.Exiftool {
+			exiftoolColumns[key] = ""
+		}
+	}
+	header := []string{"path", "class", "matches", "timestamp", "time", "camera", "latitude", "longitude", "altitude",
+		"width", "height", "orientation", "lens", "exposure", "fnumber", "iso", "duration", "hash", "sha256", "subsec", "rename_to", "error"}
+	for _, key := range sortedKeys(exifColumns) {
+		header = append(header, "exif:"+key)
+	}
+	for _, key := range sortedKeys(exiftoolColumns) {
+		header = append(header, "exiftool:"+key)
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.Write(header)
+	for _, r := range records {
+		photo := r.Photo
+		if photo == nil {
+			photo = &cache.Photo{}
+		}
+		var lat, lon, alt string
+		if photo.Location != nil {
+			lat = strconv.FormatFloat(photo.Location.Latitude, 'f', 6, 64)
+			lon = strconv.FormatFloat(photo.Location.Longitude, 'f', 6, 64)
+			if photo.Location.Altitude != nil {
+				alt = strconv.FormatFloat(*photo.Location.Altitude, 'f', -1, 64)
+			}
+		}
+		row := []string{r.Path, r.Class, r.Matches, formatInt(photo.Timestamp), r.Time, photo.Camera, lat, lon, alt,
+			formatInt(int64(photo.Width)), formatInt(int64(photo.Height)), formatInt(int64(photo.Orientation)), photo.Lens,
+			photo.Exposure, formatFloat(photo.FNumber), formatInt(int64(photo.ISO)), formatFloat(photo.Duration),
+			photo.Hash, photo.SHA256, photo.SubSec, r.RenameTo, r.Error}
+		for _, key := range sortedKeys(exifColumns) {
+			row = append(row, r.Exif[key])
+		}
+		for _, key := range sortedKeys(exiftoolColumns) {
+			row = append(row, r.Exiftool[key])
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Info prints the metadata of photo and video files, as read by goexif
+// and exiftool, and the values that Photo derives from them.
+func Info(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpInfo, nil, []string{"format", "target", "match"})
+	format := args.choice("format", "text", "json", "csv")
+	match := args.choice("match", matchBoth, matchMetadata, matchContent)
+	if len(args.positional) == 0 {
 		ShowHelpInfo()
 		return
 	}
-	et := exiftool.Create(conf.Perl)
-	et.Dump(fileName)
+	var index *targetIndex
+	if name := args.value("target", ""); name != "" {
+		target = conf.GetTarget(name)
+		if target == nil {
+			log.Fatal("Target not found: " + name)
+		}
+		index = newTargetIndex(loadLocalCache(conf, target))
+	}
+	et := exiftool.Create(conf.Perl, 1)
+	defer et.Close()
+	var records []infoRecord
+	for _, path := range infoFiles(args.positional) {
+		records = append(records, analyzeInfo(path, target, index, match, et))
+	}
+	switch format {
+	case "json":
+		if records == nil {
+			records = []infoRecord{}
+		}
+		out, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Println(string(out))
+	case "csv":
+		err := printInfoCSV(records)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	default:
+		printInfoText(records)
+	}
 }
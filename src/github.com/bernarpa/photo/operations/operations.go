@@ -8,6 +8,7 @@ import (
 
 	"github.com/bernarpa/photo/cache"
 	"github.com/bernarpa/photo/config"
+	"github.com/bernarpa/photo/fileops"
 )
 
 type helpFunction func()
@@ -24,7 +25,7 @@ func RunCommandFunction(cmd commandFunction, help helpFunction, requiresTarget b
 	start := time.Now()
 	config, err := config.Load()
 	if err != nil {
-		log.Fatal("Error while loading the configuration file")
+		log.Fatal("Error while loading the configuration file: " + err.Error())
 	}
 	if requiresTarget {
 		targetName := os.Args[2]
@@ -37,13 +38,13 @@ func RunCommandFunction(cmd commandFunction, help helpFunction, requiresTarget b
 		cmd(config, nil)
 	}
 	duration := time.Since(start)
-	fmt.Printf("%f minutes elapsed\n", duration.Minutes())
+	fmt.Fprintf(os.Stderr, "%f minutes elapsed\n", duration.Minutes())
 }
 
 func loadLocalCache(conf *config.Config, target *config.Target) *cache.Cache {
 	myCache, err := cache.Load(conf, target)
 	if err != nil {
-		fmt.Println("Cannot load local cache, performing update...")
+		log.Println("Cannot load local cache, performing update...")
 		Update(conf, target)
 		myCache, err = cache.Load(conf, target)
 		if err != nil {
@@ -52,8 +53,15 @@ func loadLocalCache(conf *config.Config, target *config.Target) *cache.Cache {
 		return myCache
 	}
 	now := time.Now().Unix()
-	if now-myCache.LastUpdate > 86400 {
-		fmt.Println("Local cache is older than 1 day, performing update...")
+	if myCache.Version < cache.Version {
+		log.Println("Local cache has an outdated format, migrating it...")
+		Update(conf, target)
+		myCache, err = cache.Load(conf, target)
+		if err != nil {
+			log.Fatal("Error while updating cache: " + err.Error())
+		}
+	} else if now-myCache.LastUpdate > 86400 {
+		log.Println("Local cache is older than 1 day, performing update...")
 		Update(conf, target)
 		myCache, err = cache.Load(conf, target)
 		if err != nil {
@@ -62,3 +70,92 @@ func loadLocalCache(conf *config.Config, target *config.Target) *cache.Cache {
 	}
 	return myCache
 }
+
+// updateMovedPhotos updates the cache of a local target after some of its
+// photos have been moved, so that a full update isn't needed: moved maps
+// the old paths to the new ones. The photos moved out of the collections
+// of the target are removed from the cache.
+func updateMovedPhotos(target *config.Target, myCache *cache.Cache, moved map[string]string) {
+	if len(moved) == 0 {
+		return
+	}
+	var kept []cache.Photo
+	for _, photo := range myCache.Photos {
+		newPath, ok := moved[photo.Path]
+		if !ok {
+			kept = append(kept, photo)
+			continue
+		}
+		info, err := os.Stat(newPath)
+		if err != nil || !inCollections(target, newPath) {
+			continue
+		}
+		photo.Path = newPath
+		photo.ModTime = info.ModTime().UnixNano()
+		kept = append(kept, photo)
+	}
+	myCache.Photos = kept
+	err := myCache.Save(target.GetLocalCachePath())
+	if err != nil {
+		log.Fatal("Cache file writing error: " + err.Error())
+	}
+}
+
+// inCollections checks whether a path is in one of the collections
+// of a target.
+func inCollections(target *config.Target, path string) bool {
+	for _, collection := range target.Collections {
+		if inDir(path, collection) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCacheAsIs loads the cache of the target without updating it, for
+// the operations that mustn't change anything, like the dry runs.
+func loadCacheAsIs(conf *config.Config, target *config.Target) *cache.Cache {
+	myCache, err := cache.Load(conf, target)
+	if err != nil {
+		log.Fatal("Cannot load local cache, run photo update first: " + err.Error())
+	}
+	if myCache.Version < cache.Version || time.Now().Unix()-myCache.LastUpdate > 86400 {
+		log.Println("Local cache is outdated, run photo update for accurate results")
+	}
+	return myCache
+}
+
+// fileOperations returns the FileOps used by the operations that reorganize
+// photos: either a dry run, or the filesystem with an undo journal in the
+// working directory. The returned function prints the planned changes
+// or closes the journal, so it must be called at the end of the operation.
+func fileOperations(dryRun bool, format string) (fileops.FileOps, func()) {
+	return fileOperationsIn(".", nil, dryRun, format)
+}
+
+// fileOperationsIn works like fileOperations, but the journal is written
+// to the specified directory. If remote isn't nil, the operation runs on
+// that SSH target, so the journal must be undone there.
+func fileOperationsIn(journalDir string, remote *config.Target, dryRun bool, format string) (fileops.FileOps, func()) {
+	if dryRun {
+		plan := fileops.NewDryRun()
+		return plan, func() {
+			plan.Print(format)
+		}
+	}
+	journal, err := fileops.CreateJournal(journalDir)
+	if err != nil {
+		log.Fatal("Journal creation error: " + err.Error())
+	}
+	return journal, func() {
+		journal.Close()
+		if journal.Empty() {
+			return
+		}
+		if remote != nil {
+			fmt.Printf("Changes recorded in %s on %s, run photo undo %s --target %s to revert them\n", journal.Path, remote.Name, journal.Path, remote.Name)
+		} else {
+			fmt.Printf("Changes recorded in %s, run photo undo to revert them\n", journal.Path)
+		}
+	}
+}
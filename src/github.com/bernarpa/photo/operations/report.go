@@ -0,0 +1,330 @@
+package operations
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+)
+
+// ShowHelpReport prints the help for the report operation.
+func ShowHelpReport() {
+	fmt.Println()
+	fmt.Println("Usage: photo report <TARGET> [--out FILE]")
+	fmt.Println()
+	fmt.Println("   TARGET     one of the targets defined in config.json")
+	fmt.Println("   --out      HTML file to write (default: report.html)")
+	fmt.Println()
+}
+
+// Size of the charts of the HTML report, in pixels. The viewBox and the
+// axis of the charts in reportTemplate must match them.
+const (
+	chartWidth   = 900.0
+	chartHeight  = 200.0
+	timelineLane = 24.0
+)
+
+// reportBar is a month of the chart of the photos per month.
+type reportBar struct {
+	Label  string
+	Photos int
+	Videos int
+	X      float64
+	Y      float64
+	Height float64
+}
+
+// reportTick is a year label on the time axis of the charts.
+type reportTick struct {
+	Label string
+	X     float64
+}
+
+// reportCell is a month with photos in the timeline of a camera.
+type reportCell struct {
+	Label   string
+	Count   int
+	X       float64
+	Opacity float64
+}
+
+// reportLane is the timeline of a camera.
+type reportLane struct {
+	Camera string
+	Y      float64
+	Cells  []reportCell
+}
+
+// reportCollection is the storage used by a collection.
+type reportCollection struct {
+	statsCount
+	Percent float64
+}
+
+// reportPage contains the data rendered by reportTemplate.
+type reportPage struct {
+	Target         string
+	Generated      string
+	Total          *statsCount
+	Latest         []statsLatest
+	Collections    []reportCollection
+	NoExif         []string
+	Bars           []reportBar
+	Ticks          []reportTick
+	BarWidth       float64
+	Lanes          []reportLane
+	TimelineHeight float64
+}
+
+// monthRange returns all the months from the first to the last one
+// (YYYY-MM) of the specified months.
+func monthRange(months []string) []string {
+	if len(months) == 0 {
+		return nil
+	}
+	sort.Strings(months)
+	first, err1 := time.Parse("2006-01", months[0])
+	last, err2 := time.Parse("2006-01", months[len(months)-1])
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+	var all []string
+	for m := first; !m.After(last); m = m.AddDate(0, 1, 0) {
+		all = append(all, m.Format("2006-01"))
+	}
+	return all
+}
+
+// buildReportPage computes the data of the HTML report.
+func buildReportPage(photos []cache.Photo, target *config.Target) *reportPage {
+	reports := map[string]bool{reportLatest: true, reportCollections: true, reportNoExif: true}
+	stats := buildStats(photos, target, target.Cameras, reports, 1)
+	page := &reportPage{
+		Target:    target.Name,
+		Generated: time.Now().Format("2006-01-02 15:04"),
+		Total:     stats.Total,
+		Latest:    stats.Latest,
+		NoExif:    stats.NoExif,
+	}
+	for _, c := range stats.Collections {
+		collection := reportCollection{statsCount: c}
+		if stats.Total.Bytes > 0 {
+			collection.Percent = float64(c.Bytes) * 100 / float64(stats.Total.Bytes)
+		}
+		page.Collections = append(page.Collections, collection)
+	}
+	// Files per month, in total and per camera
+	byMonth := statsGroups{}
+	byCamera := make(map[string]statsGroups)
+	for i := range photos {
+		photo := &photos[i]
+		if photo.Timestamp == 0 {
+			continue
+		}
+		month := photo.Time().Format("2006-01")
+		byMonth.add(month, photo)
+		if photo.Camera != "" {
+			if byCamera[photo.Camera] == nil {
+				byCamera[photo.Camera] = statsGroups{}
+			}
+			byCamera[photo.Camera].add(month, photo)
+		}
+	}
+	var months []string
+	for month := range byMonth {
+		months = append(months, month)
+	}
+	months = monthRange(months)
+	if len(months) == 0 {
+		return page
+	}
+	page.BarWidth = chartWidth / float64(len(months))
+	position := make(map[string]float64)
+	maxCount := 0
+	for i, month := range months {
+		position[month] = float64(i) * page.BarWidth
+		if c := byMonth[month]; c != nil && c.Photos+c.Videos > maxCount {
+			maxCount = c.Photos + c.Videos
+		}
+		if month[5:] == "01" || i == 0 {
+			page.Ticks = append(page.Ticks, reportTick{Label: month[:4], X: position[month]})
+		}
+	}
+	for _, month := range months {
+		bar := reportBar{Label: month, X: position[month], Y: chartHeight}
+		if c := byMonth[month]; c != nil {
+			bar.Photos, bar.Videos = c.Photos, c.Videos
+			bar.Height = float64(c.Photos+c.Videos) * chartHeight / float64(maxCount)
+			bar.Y = chartHeight - bar.Height
+		}
+		page.Bars = append(page.Bars, bar)
+	}
+	var cameras []string
+	for camera := range byCamera {
+		cameras = append(cameras, camera)
+	}
+	sort.Strings(cameras)
+	for i, camera := range cameras {
+		lane := reportLane{Camera: camera, Y: float64(i) * timelineLane}
+		laneMax := 0
+		for _, c := range byCamera[camera] {
+			if c.Photos+c.Videos > laneMax {
+				laneMax = c.Photos + c.Videos
+			}
+		}
+		for _, c := range byCamera[camera].sorted() {
+			count := c.Photos + c.Videos
+			lane.Cells = append(lane.Cells, reportCell{
+				Label:   c.Name,
+				Count:   count,
+				X:       position[c.Name],
+				Opacity: 0.25 + 0.75*float64(count)/float64(laneMax),
+			})
+		}
+		page.Lanes = append(page.Lanes, lane)
+	}
+	page.TimelineHeight = float64(len(cameras)) * timelineLane
+	return page
+}
+
+// reportTemplate is the HTML report, which must be self-contained: the
+// CSS is inline and the charts are SVG.
+const reportTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Photo report: {{.Target}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; max-width: 1200px; margin: 2em auto; padding: 0 1em; }
+h1 { margin-bottom: 0; }
+h2 { margin-top: 2em; border-bottom: 1px solid #ddd; padding-bottom: .2em; }
+.subtitle { color: #777; }
+.summary { display: flex; gap: 1em; flex-wrap: wrap; margin-top: 1.5em; }
+.card { background: #f4f6f8; border-radius: 6px; padding: .8em 1.2em; min-width: 8em; }
+.card b { display: block; font-size: 1.6em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .35em .6em; border-bottom: 1px solid #eee; }
+td.num, th.num { text-align: right; }
+.bar { background: #e8eef4; height: .8em; border-radius: 3px; }
+.bar div { background: #3b7dd8; height: 100%; border-radius: 3px; }
+.missing { color: #c0392b; }
+svg { width: 100%; height: auto; }
+svg text { font-size: 11px; fill: #555; }
+.chart rect { fill: #3b7dd8; }
+.lanes rect { fill: #2e9e6b; }
+details { margin-top: .5em; }
+</style>
+</head>
+<body>
+<h1>Photo report: {{.Target}}</h1>
+<div class="subtitle">Generated on {{.Generated}}</div>
+<div class="summary">
+<div class="card"><b>{{.Total.Photos}}</b>photos</div>
+<div class="card"><b>{{.Total.Videos}}</b>videos</div>
+<div class="card"><b>{{bytes .Total.Bytes}}</b>total size</div>
+<div class="card"><b>{{len .NoExif}}</b>files without Exif</div>
+</div>
+
+<h2>Photos per month</h2>
+{{if .Bars}}
+<svg class="chart" viewBox="0 -10 900 230" role="img">
+{{- $w := .BarWidth}}
+{{- range .Bars}}
+<rect x="{{coord .X}}" y="{{coord .Y}}" width="{{coord $w}}" height="{{coord .Height}}"><title>{{.Label}}: {{.Photos}} photos, {{.Videos}} videos</title></rect>
+{{- end}}
+<line x1="0" y1="200" x2="900" y2="200" stroke="#999"/>
+{{- range .Ticks}}
+<text x="{{coord .X}}" y="215">{{.Label}}</text>
+{{- end}}
+</svg>
+{{else}}
+<p>No photos with a timestamp.</p>
+{{end}}
+
+<h2>Camera timelines</h2>
+{{if .Lanes}}
+<svg class="lanes" viewBox="-160 0 1060 {{coord .TimelineHeight}}" role="img">
+{{- $w := .BarWidth}}
+{{- range .Lanes}}
+<text x="-155" y="{{coord .Y}}" dy="16">{{.Camera}}</text>
+{{- $y := .Y}}
+{{- range .Cells}}
+<rect x="{{coord .X}}" y="{{coord $y}}" width="{{coord $w}}" height="18" fill-opacity="{{printf "%.2f" .Opacity}}"><title>{{.Label}}: {{.Count}} files</title></rect>
+{{- end}}
+{{- end}}
+</svg>
+{{else}}
+<p>No photos with camera and timestamp.</p>
+{{end}}
+
+<h2>Latest photo per camera</h2>
+<table>
+<tr><th>Camera</th><th>Time</th><th>Path</th></tr>
+{{- range .Latest}}
+<tr><td>{{if .Camera}}{{.Camera}}{{else}}-{{end}}</td>{{if .Path}}<td>{{.Time}}</td><td>{{.Path}}</td>{{else}}<td class="missing" colspan="2">no photos</td>{{end}}</tr>
+{{- end}}
+</table>
+
+<h2>Storage by collection</h2>
+<table>
+<tr><th>Collection</th><th class="num">Photos</th><th class="num">Videos</th><th class="num">Size</th><th style="width:30%"></th></tr>
+{{- range .Collections}}
+<tr><td>{{.Name}}</td><td class="num">{{.Photos}}</td><td class="num">{{.Videos}}</td><td class="num">{{bytes .Bytes}}</td><td><div class="bar"><div style="width: {{printf "%.1f" .Percent}}%"></div></div></td></tr>
+{{- end}}
+</table>
+
+<h2>Files without Exif</h2>
+{{if .NoExif}}
+<p>{{len .NoExif}} files have no camera or timestamp, so they can't be renamed and are recognized only by their content.</p>
+<details><summary>Show the files</summary>
+<ul>
+{{- range .NoExif}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+</details>
+{{else}}
+<p>All the files have camera and timestamp.</p>
+{{end}}
+</body>
+</html>
+`
+
+// Report writes an HTML report about the specified target, which can be
+// opened without Photo: photos per month, camera timelines, latest photo
+// per camera, storage by collection and files without Exif.
+func Report(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpReport, nil, []string{"out"})
+	outPath := args.value("out", "report.html")
+	myCache := loadLocalCache(conf, target)
+	var photos []cache.Photo
+	for _, photo := range myCache.Photos {
+		if !photo.Ignored {
+			photos = append(photos, photo)
+		}
+	}
+	tmpl := template.Must(template.New("report").Funcs(template.FuncMap{
+		"bytes": formatBytes,
+		"coord": func(f float64) string { return fmt.Sprintf("%.1f", f) },
+	}).Parse(reportTemplate))
+	f, err := os.Create(outPath)
+	if err != nil {
+		log.Fatal("Report creation error: " + err.Error())
+	}
+	err = tmpl.Execute(f, buildReportPage(photos, target))
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		log.Fatal("Report writing error: " + err.Error())
+	}
+	fmt.Printf("Report written to %s\n", outPath)
+}
@@ -0,0 +1,669 @@
+package operations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+	"github.com/bernarpa/photo/imaging"
+	"github.com/bernarpa/photo/ssh"
+)
+
+// ShowHelpServe prints the help for the serve operation.
+func ShowHelpServe() {
+	fmt.Println()
+	fmt.Println("Usage: photo serve <TARGET> [--listen ADDR] [--size N]")
+	fmt.Println()
+	fmt.Println("   TARGET     one of the targets defined in config.json")
+	fmt.Println("   --listen   address of the web server (default: localhost:8080),")
+	fmt.Println("              use :8080 to make it reachable from other computers")
+	fmt.Println("   --size     size of the thumbnails (default: 256); the thumbnails of")
+	fmt.Println("              SSH targets must be downloaded first with photo thumbs")
+	fmt.Println()
+	fmt.Println("   API (GET, JSON):")
+	fmt.Println("     /api/days      files per day (?camera=NAME for a single camera)")
+	fmt.Println("     /api/cameras   files per camera")
+	fmt.Println("     /api/photos    files of a day (?day=YYYY-MM-DD or undated) or matching")
+	fmt.Println("                    the options of photo find (e.g. ?camera=Canon*&type=video),")
+	fmt.Println("                    with ?offset=N&limit=N for paging")
+	fmt.Println("     /api/photo     metadata of a file (?path=PATH)")
+	fmt.Println("     /thumb         thumbnail of a file (?path=PATH)")
+	fmt.Println("     /original      original file (?path=PATH)")
+	fmt.Println()
+}
+
+// undatedDay is the day of the files without a timestamp.
+const undatedDay = "undated"
+
+// serveFilters are the query parameters of /api/photos that are passed
+// to findFilters, like the options of photo find.
+var serveFilters = []string{"from", "to", "camera", "path", "regex", "type", "min-size", "max-size", "no-exif", "bbox"}
+
+// servePhoto is a file of the collection as returned by the API.
+type servePhoto struct {
+	cache.Photo
+	Kind      string `json:"kind"`
+	Time      string `json:"time,omitempty"`
+	Day       string `json:"day"`
+	Thumbnail bool   `json:"thumbnail"`
+}
+
+// servePage is a page of the files returned by /api/photos.
+type servePage struct {
+	Total  int          `json:"total"`
+	Offset int          `json:"offset"`
+	Photos []servePhoto `json:"photos"`
+}
+
+// photoServer serves the photos of a target, read from its cache when
+// the server starts.
+type photoServer struct {
+	target   *config.Target
+	photos   []cache.Photo
+	byPath   map[string]*cache.Photo
+	thumbDir string
+	size     int
+	// Thumbnails of local targets are generated on demand, by at most
+	// workers goroutines and once per file
+	workers    chan bool
+	mutex      sync.Mutex
+	generating map[string]*sync.Mutex
+	client     *ssh.Client
+}
+
+func photoDay(photo *cache.Photo) string {
+	if photo.Timestamp == 0 {
+		return undatedDay
+	}
+	return photo.Time().Format("2006-01-02")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	out, _ := json.Marshal(map[string]string{"error": message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// lookup returns the photo of the cache specified by the path query
+// parameter. Only the files of the cache can be served.
+func (s *photoServer) lookup(w http.ResponseWriter, r *http.Request) *cache.Photo {
+	photo, exists := s.byPath[r.URL.Query().Get("path")]
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "file not found")
+		return nil
+	}
+	return photo
+}
+
+func (s *photoServer) toServePhoto(photo *cache.Photo) servePhoto {
+	entry := servePhoto{Photo: *photo, Kind: photo.Kind(), Time: photoTime(photo), Day: photoDay(photo)}
+	if photo.HasThumbnail() {
+		if s.target.TargetType == "local" {
+			entry.Thumbnail = true
+		} else if _, err := os.Stat(photo.ThumbnailPath(s.thumbDir)); err == nil {
+			entry.Thumbnail = true
+		}
+	}
+	return entry
+}
+
+// handleDays lists the days with files.
+func (s *photoServer) handleDays(w http.ResponseWriter, r *http.Request) {
+	camera := r.URL.Query().Get("camera")
+	days := statsGroups{}
+	for i := range s.photos {
+		if camera == "" || s.photos[i].Camera == camera {
+			days.add(photoDay(&s.photos[i]), &s.photos[i])
+		}
+	}
+	// Latest day first, undated files last
+	byName := days.sorted()
+	sorted := make([]statsCount, 0, len(byName))
+	for i := len(byName) - 1; i >= 0; i-- {
+		if byName[i].Name != undatedDay {
+			sorted = append(sorted, byName[i])
+		}
+	}
+	if undated, exists := days[undatedDay]; exists {
+		sorted = append(sorted, *undated)
+	}
+	writeJSON(w, sorted)
+}
+
+func (s *photoServer) handleCameras(w http.ResponseWriter, r *http.Request) {
+	cameras := statsGroups{}
+	for i := range s.photos {
+		cameras.add(s.photos[i].Camera, &s.photos[i])
+	}
+	writeJSON(w, cameras.sorted())
+}
+
+// handlePhotos lists the files of a day or matching the filters of
+// photo find, by date.
+func (s *photoServer) handlePhotos(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	args := &cmdArgs{options: make(map[string][]string)}
+	for _, name := range serveFilters {
+		if values, exists := query[name]; exists {
+			args.options[name] = values
+		}
+	}
+	filters, err := findFilters(args)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if day := query.Get("day"); day != "" {
+		filters = append(filters, func(photo *cache.Photo) bool {
+			return photoDay(photo) == day
+		})
+	}
+	sortBy := query.Get("sort")
+	if sortBy == "" {
+		sortBy = "date"
+	} else if sortBy != "date" && sortBy != "path" && sortBy != "size" && sortBy != "camera" {
+		writeJSONError(w, http.StatusBadRequest, "invalid sort, expected date, path, size or camera")
+		return
+	}
+	var paging [2]int
+	for i, name := range []string{"offset", "limit"} {
+		if value := query.Get(name); value != "" {
+			paging[i], err = strconv.Atoi(value)
+			if err != nil || paging[i] < 0 {
+				writeJSONError(w, http.StatusBadRequest, "invalid "+name)
+				return
+			}
+		}
+	}
+	var matching []cache.Photo
+	for i := range s.photos {
+		photo := &s.photos[i]
+		matches := true
+		for _, filter := range filters {
+			if !filter(photo) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			matching = append(matching, *photo)
+		}
+	}
+	_, reverse := query["reverse"]
+	if sortBy != "date" || reverse {
+		sortPhotos(matching, sortBy, reverse)
+	}
+	page := servePage{Total: len(matching), Offset: paging[0], Photos: []servePhoto{}}
+	if paging[0] < len(matching) {
+		matching = matching[paging[0]:]
+		if paging[1] > 0 && len(matching) > paging[1] {
+			matching = matching[:paging[1]]
+		}
+		for i := range matching {
+			page.Photos = append(page.Photos, s.toServePhoto(&matching[i]))
+		}
+	}
+	writeJSON(w, page)
+}
+
+func (s *photoServer) handlePhoto(w http.ResponseWriter, r *http.Request) {
+	if photo := s.lookup(w, r); photo != nil {
+		writeJSON(w, s.toServePhoto(photo))
+	}
+}
+
+// thumbnail returns the path of the thumbnail of a photo. The missing
+// thumbnails of local targets are generated.
+func (s *photoServer) thumbnail(photo *cache.Photo) (string, error) {
+	path := photo.ThumbnailPath(s.thumbDir)
+	if !photo.HasThumbnail() {
+		return "", os.ErrNotExist
+	}
+	if _, err := os.Stat(path); err == nil || s.target.TargetType != "local" {
+		return path, err
+	}
+	s.mutex.Lock()
+	lock, exists := s.generating[photo.SHA256]
+	if !exists {
+		lock = &sync.Mutex{}
+		s.generating[photo.SHA256] = lock
+	}
+	s.mutex.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+	// Another request may have generated it in the meantime
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	s.workers <- true
+	defer func() { <-s.workers }()
+	return path, imaging.WriteThumbnail(photo.Path, path, s.size, photo.Orientation)
+}
+
+func (s *photoServer) handleThumb(w http.ResponseWriter, r *http.Request) {
+	photo := s.lookup(w, r)
+	if photo == nil {
+		return
+	}
+	path, err := s.thumbnail(photo)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "thumbnail not available")
+		return
+	}
+	// Thumbnails are named after the content, so they never change
+	w.Header().Set("Cache-Control", "max-age=31536000, immutable")
+	http.ServeFile(w, r, path)
+}
+
+// sshClient returns the SSH connection used to proxy the originals,
+// which is established on the first request.
+func (s *photoServer) sshClient(reconnect bool) (*ssh.Client, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.client != nil && reconnect {
+		s.client.Close()
+		s.client = nil
+	}
+	if s.client == nil {
+		client, session, err := ssh.Connect(s.target)
+		if err != nil {
+			return nil, err
+		}
+		session.Close()
+		s.client = client
+	}
+	return s.client, nil
+}
+
+func (s *photoServer) handleOriginal(w http.ResponseWriter, r *http.Request) {
+	photo := s.lookup(w, r)
+	if photo == nil {
+		return
+	}
+	if s.target.TargetType == "local" {
+		http.ServeFile(w, r, photo.Path)
+		return
+	}
+	client, err := s.sshClient(false)
+	if err == nil {
+		// Probes the connection, which may have been dropped meanwhile
+		_, _, err = client.SendRequest("keepalive@openssh.com", true, nil)
+		if err != nil {
+			client, err = s.sshClient(true)
+		}
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, "SSH connection error: "+err.Error())
+		return
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(photo.Path)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	// Browsers need ranges to seek videos, and Safari to play them at all
+	w.Header().Set("Accept-Ranges", "bytes")
+	start, length, partial, err := parseRange(r.Header.Get("Range"), photo.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", photo.Size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	cmd := "cat " + ssh.Quote(photo.Path)
+	status := http.StatusOK
+	if partial {
+		cmd = fmt.Sprintf("tail -c +%d %s | head -c %d", start+1, ssh.Quote(photo.Path), length)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, photo.Size))
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	err = ssh.Stream(client, cmd, w)
+	if err != nil {
+		log.Printf("Unable to send %s: %s\n", photo.Path, err.Error())
+	}
+}
+
+// errRangeNotSatisfiable is returned by parseRange when the requested
+// range is outside of the file.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRange parses the Range header of a request for a file of the
+// specified size, returning the first byte and the length to send. Only
+// single byte ranges are supported, partial is false when the whole
+// file must be sent, e.g. because there is no Range header.
+func parseRange(header string, size int64) (start int64, length int64, partial bool, err error) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	dash := strings.Index(spec, "-")
+	if spec == header || strings.Contains(spec, ",") || dash < 0 {
+		return 0, size, false, nil
+	}
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+	end := size - 1
+	if first == "" {
+		// Suffix range: the last bytes of the file
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, false, nil
+	}
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, size, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+	return start, end - start + 1, true, nil
+}
+
+func (s *photoServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, serveIndex)
+}
+
+// Serve starts a web server to browse the photos of the target by day
+// and camera. The files are read from the cache, so they're those of the
+// last update.
+func Serve(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpServe, nil, []string{"listen", "size"})
+	listen := args.value("listen", "localhost:8080")
+	size := thumbSize(args)
+	myCache := loadLocalCache(conf, target)
+	s := &photoServer{
+		target:     target,
+		byPath:     make(map[string]*cache.Photo),
+		thumbDir:   target.GetThumbnailsDir(size),
+		size:       size,
+		workers:    make(chan bool, conf.Workers),
+		generating: make(map[string]*sync.Mutex),
+	}
+	for _, photo := range myCache.Photos {
+		if !photo.Ignored {
+			s.photos = append(s.photos, photo)
+		}
+	}
+	sortPhotos(s.photos, "date", false)
+	for i := range s.photos {
+		s.byPath[s.photos[i].Path] = &s.photos[i]
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/days", s.handleDays)
+	mux.HandleFunc("/api/cameras", s.handleCameras)
+	mux.HandleFunc("/api/photos", s.handlePhotos)
+	mux.HandleFunc("/api/photo", s.handlePhoto)
+	mux.HandleFunc("/thumb", s.handleThumb)
+	mux.HandleFunc("/original", s.handleOriginal)
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	url := listen
+	if strings.HasPrefix(url, ":") {
+		url = "localhost" + url
+	}
+	fmt.Printf("Serving %d files of %s on http://%s/\n", len(s.photos), target.Name, url)
+	log.Fatal(server.ListenAndServe())
+}
+
+// serveIndex is the web page to browse the photos, which uses the API:
+// the days are listed on the left, the thumbnails of the selected day on
+// the right and a click on a thumbnail shows the original.
+const serveIndex = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Photo</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; margin: 0; display: flex; height: 100vh; }
+nav { width: 16em; flex: none; overflow-y: auto; background: #f4f6f8; border-right: 1px solid #ddd; }
+nav select { width: calc(100% - 1.6em); margin: .8em; }
+nav h3 { font-size: .85em; color: #777; margin: 1em .8em .3em; }
+nav a { display: flex; justify-content: space-between; padding: .2em .8em; color: #222; text-decoration: none; }
+nav a:hover { background: #e8eef4; }
+nav a.selected { background: #3b7dd8; color: #fff; }
+main { flex: auto; overflow-y: auto; padding: 0 1.2em; }
+.grid { display: flex; flex-wrap: wrap; gap: 6px; }
+.tile { width: 160px; height: 160px; background: #eee; display: flex; align-items: center; justify-content: center; cursor: pointer; position: relative; overflow: hidden; }
+.tile img { max-width: 100%; max-height: 100%; }
+.tile span { font-size: .8em; color: #777; padding: .5em; word-break: break-all; text-align: center; }
+.tile .badge { position: absolute; right: 4px; bottom: 4px; background: rgba(0,0,0,.6); color: #fff; font-size: .75em; padding: 1px 5px; border-radius: 3px; }
+.more { margin: 1em 0; }
+#viewer { position: fixed; inset: 0; background: rgba(0,0,0,.92); display: none; color: #eee; }
+#viewer.open { display: flex; }
+#media { flex: auto; display: flex; align-items: center; justify-content: center; min-width: 0; }
+#media img, #media video { max-width: 100%; max-height: 100vh; }
+#media a { color: #8bb8f0; }
+#details { width: 20em; flex: none; overflow-y: auto; padding: 1em; background: #111; font-size: .85em; }
+#details table { border-collapse: collapse; }
+#details td { padding: .2em .4em; vertical-align: top; word-break: break-all; }
+#details td:first-child { color: #999; white-space: nowrap; }
+#details button { margin: 0 .3em 1em 0; }
+</style>
+</head>
+<body>
+<nav>
+<select id="camera"><option value="">All cameras</option></select>
+<div id="days"></div>
+</nav>
+<main>
+<h2 id="title">Select a day</h2>
+<div class="grid" id="grid"></div>
+<div class="more"><button id="more" hidden>Show more</button></div>
+</main>
+<div id="viewer">
+<div id="media"></div>
+<div id="details"></div>
+</div>
+<script>
+"use strict";
+const pageSize = 200;
+let day = null, offset = 0, photos = [], current = -1;
+
+function $(id) { return document.getElementById(id); }
+
+function el(tag, attrs, text) {
+  const e = document.createElement(tag);
+  Object.keys(attrs || {}).forEach(function (k) { e.setAttribute(k, attrs[k]); });
+  if (text !== undefined) e.textContent = text;
+  return e;
+}
+
+function get(url) {
+  return fetch(url).then(function (r) {
+    return r.json().then(function (data) {
+      if (!r.ok) throw new Error(data.error || r.statusText);
+      return data;
+    });
+  });
+}
+
+function fileURL(kind, path) { return "/" + kind + "?path=" + encodeURIComponent(path); }
+
+function formatBytes(n) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return (i ? n.toFixed(1) : n) + " " + units[i];
+}
+
+function loadCameras() {
+  get("/api/cameras").then(function (cameras) {
+    cameras.forEach(function (c) {
+      if (c.name) $("camera").appendChild(el("option", {value: c.name}, c.name + " (" + (c.photos + c.videos) + ")"));
+    });
+  });
+}
+
+function loadDays() {
+  const camera = $("camera").value;
+  get("/api/days?camera=" + encodeURIComponent(camera)).then(function (days) {
+    const list = $("days");
+    list.textContent = "";
+    let month = null;
+    days.forEach(function (d) {
+      const m = d.name.substring(0, 7);
+      if (m !== month) {
+        month = m;
+        list.appendChild(el("h3", {}, d.name === "undated" ? "Undated" : m));
+      }
+      const a = el("a", {href: "#" + d.name});
+      a.appendChild(el("span", {}, d.name));
+      a.appendChild(el("span", {}, String(d.photos + d.videos)));
+      if (d.name === day) a.className = "selected";
+      list.appendChild(a);
+    });
+    if (!day && days.length) location.hash = days[0].name;
+  });
+}
+
+function showDay() {
+  day = decodeURIComponent(location.hash.substring(1)) || null;
+  document.querySelectorAll("nav a").forEach(function (a) {
+    a.className = a.getAttribute("href") === "#" + day ? "selected" : "";
+  });
+  $("grid").textContent = "";
+  photos = [];
+  offset = 0;
+  if (day) loadPhotos();
+}
+
+function loadPhotos() {
+  let url = "/api/photos?day=" + encodeURIComponent(day) + "&offset=" + offset + "&limit=" + pageSize;
+  if ($("camera").value) url += "&camera=" + encodeURIComponent($("camera").value);
+  get(url).then(function (page) {
+    $("title").textContent = day + " (" + page.total + " files)";
+    page.photos.forEach(function (p) {
+      const i = photos.length;
+      photos.push(p);
+      const tile = el("div", {class: "tile", title: p.path});
+      if (p.thumbnail) {
+        tile.appendChild(el("img", {src: fileURL("thumb", p.path), loading: "lazy", alt: ""}));
+      } else {
+        tile.appendChild(el("span", {}, p.path.split(/[\\/]/).pop()));
+      }
+      if (p.kind === "video") tile.appendChild(el("div", {class: "badge"}, p.duration ? Math.round(p.duration) + " s" : "video"));
+      tile.onclick = function () { openViewer(i); };
+      $("grid").appendChild(tile);
+    });
+    offset += page.photos.length;
+    $("more").hidden = offset >= page.total;
+  }).catch(function (e) { $("title").textContent = e.message; });
+}
+
+function openViewer(i) {
+  current = i;
+  const p = photos[i];
+  const media = $("media");
+  media.textContent = "";
+  const ext = p.path.split(".").pop().toLowerCase();
+  if (p.kind === "video") {
+    media.appendChild(el("video", {src: fileURL("original", p.path), controls: "", autoplay: ""}));
+  } else if (["jpg", "jpeg", "png", "gif", "webp"].indexOf(ext) >= 0) {
+    media.appendChild(el("img", {src: fileURL("original", p.path), alt: ""}));
+  } else {
+    media.appendChild(el("a", {href: fileURL("original", p.path), download: ""}, "No preview, download the file"));
+  }
+  const details = $("details");
+  details.textContent = "";
+  [["\u2190", -1], ["\u2192", 1], ["\u2715", 0]].forEach(function (b) {
+    const button = el("button", {}, b[0]);
+    button.onclick = function () { b[1] ? move(b[1]) : closeViewer(); };
+    details.appendChild(button);
+  });
+  const table = el("table");
+  const rows = [
+    ["Path", p.path], ["Time", p.time], ["Camera", p.camera], ["Lens", p.lens],
+    ["Size", formatBytes(p.size)], ["Dimensions", p.width ? p.width + " x " + p.height : ""],
+    ["Duration", p.duration ? p.duration.toFixed(1) + " s" : ""], ["ISO", p.iso],
+    ["Exposure", p.exposure], ["Aperture", p.fnumber ? "f/" + p.fnumber : ""],
+    ["Location", p.location ? p.location.lat.toFixed(6) + ", " + p.location.lon.toFixed(6) : ""],
+    ["SHA-256", p.sha256]
+  ];
+  rows.forEach(function (r) {
+    if (!r[1]) return;
+    const tr = el("tr");
+    tr.appendChild(el("td", {}, r[0]));
+    tr.appendChild(el("td", {}, String(r[1])));
+    table.appendChild(tr);
+  });
+  details.appendChild(table);
+  if (p.location) {
+    details.appendChild(el("a", {href: "https://www.openstreetmap.org/?mlat=" + p.location.lat + "&mlon=" + p.location.lon + "#map=15/" + p.location.lat + "/" + p.location.lon, target: "_blank", rel: "noopener"}, "Show on the map"));
+  }
+  $("viewer").className = "open";
+}
+
+function move(delta) {
+  const i = current + delta;
+  if (i >= 0 && i < photos.length) openViewer(i);
+}
+
+function closeViewer() {
+  $("viewer").className = "";
+  $("media").textContent = "";
+  current = -1;
+}
+
+document.addEventListener("keydown", function (e) {
+  if (current < 0) return;
+  if (e.key === "Escape") closeViewer();
+  if (e.key === "ArrowLeft") move(-1);
+  if (e.key === "ArrowRight") move(1);
+});
+$("camera").onchange = function () { loadDays(); showDay(); };
+$("more").onclick = loadPhotos;
+window.onhashchange = showDay;
+loadCameras();
+loadDays();
+showDay();
+</script>
+</body>
+</html>
+`
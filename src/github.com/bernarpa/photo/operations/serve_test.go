@@ -0,0 +1,38 @@
+package operations
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header  string
+		start   int64
+		length  int64
+		partial bool
+		err     error
+	}{
+		{"", 0, 1000, false, nil},
+		{"bytes=0-", 0, 1000, true, nil},
+		{"bytes=0-1", 0, 2, true, nil},
+		{"bytes=100-199", 100, 100, true, nil},
+		{"bytes=900-", 900, 100, true, nil},
+		{"bytes=900-5000", 900, 100, true, nil},
+		{"bytes=-100", 900, 100, true, nil},
+		{"bytes=-5000", 0, 1000, true, nil},
+		{"bytes=999-999", 999, 1, true, nil},
+		{"bytes=1000-", 0, 0, false, errRangeNotSatisfiable},
+		{"bytes=-0", 0, 0, false, errRangeNotSatisfiable},
+		// Invalid or unsupported ranges are ignored
+		{"bytes=0-1,5-6", 0, 1000, false, nil},
+		{"bytes=200-100", 0, 1000, false, nil},
+		{"bytes=abc", 0, 1000, false, nil},
+		{"bytes=x-", 0, 1000, false, nil},
+		{"items=0-1", 0, 1000, false, nil},
+	}
+	for _, test := range tests {
+		start, length, partial, err := parseRange(test.header, 1000)
+		if start != test.start || length != test.length || partial != test.partial || err != test.err {
+			t.Errorf("parseRange(%q) = %d, %d, %v, %v; want %d, %d, %v, %v", test.header,
+				start, length, partial, err, test.start, test.length, test.partial, test.err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package operations
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+	"github.com/bernarpa/photo/imaging"
+)
+
+// defaultSimilarDistance is the maximum Hamming distance between the
+// perceptual hashes of two similar photos, unless --distance is specified.
+const defaultSimilarDistance = 5
+
+// ShowHelpSimilar prints the help for the similar operation.
+func ShowHelpSimilar() {
+	fmt.Println()
+	fmt.Println("Usage: photo similar <TARGET> [--distance N] [--format text|json] [--move DIR [--dry-run]]")
+	fmt.Println()
+	fmt.Println("   TARGET      one of the targets defined in config.json")
+	fmt.Println("   --distance  maximum number of different bits between the perceptual")
+	fmt.Println("               hashes of similar photos (default: " + strconv.Itoa(defaultSimilarDistance) + ")")
+	fmt.Println("   --format    output format of the report, text (default) or json")
+	fmt.Println("   --move      move each group of similar photos to a subfolder of DIR,")
+	fmt.Println("               for review (local targets only)")
+	fmt.Println("   --dry-run   print the planned moves without touching the filesystem")
+	fmt.Println()
+}
+
+// similarGroup is a group of photos whose perceptual hashes are close.
+type similarGroup struct {
+	Photos []cache.Photo `json:"photos"`
+}
+
+// countMissingPHashes returns the number of images of the cache that
+// don't have a perceptual hash yet.
+func countMissingPHashes(myCache *cache.Cache) int {
+	missing := 0
+	for i := range myCache.Photos {
+		if myCache.Photos[i].NeedsPHash() {
+			missing++
+		}
+	}
+	return missing
+}
+
+// LocalPHash computes the missing perceptual hashes of the cache of a
+// target and saves it. It's meant to be run on SSH targets, where the
+// photos are.
+func LocalPHash(conf *config.Config, target *config.Target) {
+	myCache, err := cache.Load(conf, target)
+	if err != nil {
+		log.Fatal("Error while loading the cache: " + err.Error())
+	}
+	computed := myCache.UpdatePHashes(conf.Workers)
+	err = myCache.Save(target.GetLocalCachePath())
+	if err != nil {
+		log.Fatal("Cache file writing error: " + err.Error())
+	}
+	log.Printf("%d perceptual hashes computed\n", computed)
+}
+
+// groupSimilar groups the photos whose perceptual hashes are within the
+// specified Hamming distance. Similarity is transitive: if A is similar
+// to B and B to C, then A, B and C are in the same group.
+func groupSimilar(photos []cache.Photo, distance int) []similarGroup {
+	var tree imaging.BKTree
+	hashes := make([]uint64, len(photos))
+	for i, photo := range photos {
+		hashes[i], _ = imaging.ParseHash(photo.PHash)
+		tree.Add(hashes[i], i)
+	}
+	// Union-find of the similar photos
+	parent := make([]int, len(photos))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range photos {
+		for _, j := range tree.Search(hashes[i], distance) {
+			parent[find(j)] = find(i)
+		}
+	}
+	members := make(map[int][]cache.Photo)
+	for i, photo := range photos {
+		root := find(i)
+		members[root] = append(members[root], photo)
+	}
+	var groups []similarGroup
+	for _, group := range members {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(a, b int) bool { return group[a].Path < group[b].Path })
+		groups = append(groups, similarGroup{Photos: group})
+	}
+	sort.Slice(groups, func(a, b int) bool { return groups[a].Photos[0].Path < groups[b].Photos[0].Path })
+	return groups
+}
+
+// Similar finds the photos of a target that look alike, e.g. copies that
+// have been resized or re-encoded, by comparing their perceptual hashes.
+// The hashes are computed the first time and then stored in the cache.
+func Similar(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpSimilar, []string{"dry-run"}, []string{"distance", "format", "move"})
+	format := args.choice("format", "text", "json")
+	moveDir := args.value("move", "")
+	distance, err := strconv.Atoi(args.value("distance", strconv.Itoa(defaultSimilarDistance)))
+	if err != nil || distance < 0 || distance > 64 {
+		fmt.Println("Invalid value for --distance: " + args.value("distance", ""))
+		ShowHelpSimilar()
+		return
+	}
+	if moveDir != "" && target.TargetType != "local" {
+		log.Fatal("--move is only supported for local targets")
+	}
+	myCache := loadLocalCache(conf, target)
+	if countMissingPHashes(myCache) > 0 {
+		if target.TargetType == "local" {
+			computed := myCache.UpdatePHashes(conf.Workers)
+			err = myCache.Save(target.GetLocalCachePath())
+			if err != nil {
+				log.Fatal("Cache file writing error: " + err.Error())
+			}
+			log.Printf("%d perceptual hashes computed\n", computed)
+		} else if target.TargetType == "ssh" {
+			sshRun(conf, target, "localphash")
+			myCache, err = cache.Load(conf, target)
+			if err != nil {
+				log.Fatal("Error while loading the cache: " + err.Error())
+			}
+		} else {
+			log.Fatal("Unsupported target type: " + target.TargetType)
+		}
+	}
+	var photos []cache.Photo
+	for _, photo := range myCache.Photos {
+		if photo.HasPHash() && !photo.Ignored {
+			photos = append(photos, photo)
+		}
+	}
+	groups := groupSimilar(photos, distance)
+	if format == "json" {
+		if groups == nil {
+			groups = []similarGroup{}
+		}
+		out, err := json.MarshalIndent(groups, "", "  ")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Println(string(out))
+	} else {
+		for i, group := range groups {
+			fmt.Printf("Group %d (%d photos)\n", i+1, len(group.Photos))
+			for _, photo := range group.Photos {
+				fmt.Printf("  %s  %s\n", photo.PHash, photo.Path)
+			}
+		}
+		fmt.Printf("%d groups of similar photos\n", len(groups))
+	}
+	if moveDir == "" {
+		return
+	}
+	dryRun := args.flag("dry-run")
+	ops, done := fileOperations(dryRun, "text")
+	defer done()
+	moved := make(map[string]string)
+	for i, group := range groups {
+		dir := filepath.Join(moveDir, fmt.Sprintf("group_%04d", i+1))
+		ops.MkdirAll(dir)
+		for _, photo := range group.Photos {
+			oldPath := photo.Path
+			err := photo.MoveTo(dir, ops)
+			if err != nil {
+				log.Printf("Warning: unable to move photo %s to %s: %s\n", photo.Path, dir, err.Error())
+			} else if photo.Path != oldPath {
+				moved[oldPath] = photo.Path
+			}
+		}
+	}
+	if !dryRun {
+		updateMovedPhotos(target, myCache, moved)
+	}
+}
@@ -1,75 +1,414 @@
 package operations
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/bernarpa/photo/cache"
 	"github.com/bernarpa/photo/config"
 )
 
+// Reports of the stats operation.
+const (
+	reportLatest      = "latest"
+	reportCameras     = "cameras"
+	reportYears       = "years"
+	reportMonths      = "months"
+	reportCollections = "collections"
+	reportNoExif      = "noexif"
+	reportGaps        = "gaps"
+)
+
+var allReports = []string{reportLatest, reportCameras, reportYears, reportMonths, reportCollections, reportNoExif, reportGaps}
+
 // ShowHelpStats prints the help for the stats operation.
 func ShowHelpStats() {
 	fmt.Println()
-	fmt.Println("Usage: photo stats <TARGET> [--all]")
+	fmt.Println("Usage: photo stats <TARGET> [--all] [--report REPORT]... [--gap DAYS] [--format text|json]")
 	fmt.Println()
 	fmt.Println("   TARGET     one of the targets defined in config.json")
 	fmt.Println("   --all      show statistics for all cameras;")
 	fmt.Println("              if not specified, use the cameras defined in config.json")
+	fmt.Println("   --report   report to show, can be repeated (default: latest):")
+	fmt.Println("                latest       latest photo per camera")
+	fmt.Println("                cameras      photos, videos and bytes per camera")
+	fmt.Println("                years        photos, videos and bytes per year")
+	fmt.Println("                months       photos, videos and bytes per month")
+	fmt.Println("                collections  photos, videos and bytes per collection")
+	fmt.Println("                noexif       files without camera or timestamp")
+	fmt.Println("                gaps         periods without photos from the cameras")
+	fmt.Println("                all          all of the above")
+	fmt.Println("   --gap      minimum length in days of the gaps (default: 30)")
+	fmt.Println("   --format   output format, text (default) or json")
 	fmt.Println()
 }
 
-// Stats shows interesting information and statistics about the
-// specified target. The information is inferred from the cache file,
-// which will be created if it doesn't exist or it will be updated if
-// it is too old.
-func Stats(conf *config.Config, target *config.Target) {
-	allCameras := len(os.Args) == 4 && os.Args[3] == "--all"
-	myCache := loadLocalCache(conf, target)
-	// Provide the user with a summary of the most recent photo timestamps
-	// for each camera model
-	lastPhoto := make(map[string]cache.Photo)
-	for _, photo := range myCache.Photos {
+// statsCount counts the photos and videos of a group, and their size.
+type statsCount struct {
+	Name   string `json:"name"`
+	Photos int    `json:"photos"`
+	Videos int    `json:"videos"`
+	Bytes  int64  `json:"bytes"`
+}
+
+func (c *statsCount) add(photo *cache.Photo) {
+	if photo.Kind() == "video" {
+		c.Videos++
+	} else {
+		c.Photos++
+	}
+	c.Bytes += photo.Size
+}
+
+// statsGroups counts the photos by group, e.g. by camera or by year.
+type statsGroups map[string]*statsCount
+
+func (g statsGroups) add(name string, photo *cache.Photo) {
+	count, exists := g[name]
+	if !exists {
+		count = &statsCount{Name: name}
+		g[name] = count
+	}
+	count.add(photo)
+}
+
+// sorted returns the groups sorted by name.
+func (g statsGroups) sorted() []statsCount {
+	counts := []statsCount{}
+	for _, count := range g {
+		counts = append(counts, *count)
+	}
+	sort.Slice(counts, func(a, b int) bool {
+		return counts[a].Name < counts[b].Name
+	})
+	return counts
+}
+
+// statsLatest is the latest photo of a camera.
+type statsLatest struct {
+	Camera string `json:"camera"`
+	Time   string `json:"time,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+// statsGap is a period without photos from a camera. The gap after the
+// latest photo is still ongoing, and a camera without photos at all has
+// a gap with no start.
+type statsGap struct {
+	Camera  string `json:"camera"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to"`
+	Days    int    `json:"days"`
+	Ongoing bool   `json:"ongoing,omitempty"`
+}
+
+// statsReport contains the requested reports.
+type statsReport struct {
+	Total       *statsCount   `json:"total"`
+	Latest      []statsLatest `json:"latest,omitempty"`
+	Cameras     []statsCount  `json:"cameras,omitempty"`
+	Years       []statsCount  `json:"years,omitempty"`
+	Months      []statsCount  `json:"months,omitempty"`
+	Collections []statsCount  `json:"collections,omitempty"`
+	NoExif      []string      `json:"noexif,omitempty"`
+	Gaps        []statsGap    `json:"gaps,omitempty"`
+}
+
+// latestPhotos returns the latest photo of each camera.
+func latestPhotos(photos []cache.Photo, cameras []string) []statsLatest {
+	lastPhoto := make(map[string]*cache.Photo)
+	for i := range photos {
+		photo := &photos[i]
 		last, exists := lastPhoto[photo.Camera]
 		if !exists || last.Timestamp < photo.Timestamp {
 			lastPhoto[photo.Camera] = photo
 		}
 	}
-	var cameras []string
-	title := "Latest photo per camera"
-	if allCameras {
+	if cameras == nil {
 		for camera := range lastPhoto {
 			cameras = append(cameras, camera)
 		}
-		title += " (all cameras)"
 	} else {
-		cameras = target.Cameras
+		cameras = append([]string{}, cameras...)
 	}
 	sort.Strings(cameras)
-	fmt.Printf("%s\n%s\n", title, strings.Repeat("=", len(title)))
-	maxCameraLen := 0
+	var latest []statsLatest
 	for _, camera := range cameras {
-		if len(camera) > maxCameraLen {
-			maxCameraLen = len(camera)
+		entry := statsLatest{Camera: camera}
+		if photo, exists := lastPhoto[camera]; exists {
+			entry.Time = photoTime(photo)
+			entry.Path = photo.Path
 		}
+		latest = append(latest, entry)
 	}
-	maxTimestampLen := len("2008-06-01 22:11:04 +0200 CEST")
-	for _, camera := range cameras {
-		photo, exists := lastPhoto[camera]
-		var strTime string
-		var path string
-		if !exists {
-			strTime = "-"
-			path = "-"
-		} else {
-			strTime = time.Unix(photo.Timestamp, 0).String()
-			path = photo.Path
-		}
-		spaces1 := strings.Repeat(" ", maxCameraLen-len(camera))
-		spaces2 := strings.Repeat(" ", maxTimestampLen-len(strTime))
-		fmt.Printf("%s %s %s %s %s\n", camera, spaces1, strTime, spaces2, path)
+	return latest
+}
+
+// findGaps returns the periods of at least minDays days between two
+// consecutive photos of each camera, or between the latest one and now,
+// and the cameras without photos.
+func findGaps(photos []cache.Photo, cameras []string, minDays int, now time.Time) []statsGap {
+	byCamera := make(map[string][]*cache.Photo)
+	for i := range photos {
+		if photos[i].Timestamp != 0 {
+			byCamera[photos[i].Camera] = append(byCamera[photos[i].Camera], &photos[i])
+		}
+	}
+	sorted := append([]string{}, cameras...)
+	sort.Strings(sorted)
+	gaps := []statsGap{}
+	for _, camera := range sorted {
+		list := byCamera[camera]
+		if len(list) == 0 {
+			gaps = append(gaps, statsGap{Camera: camera, To: now.Format("2006-01-02"), Ongoing: true})
+			continue
+		}
+		sort.Slice(list, func(a, b int) bool { return list[a].Timestamp < list[b].Timestamp })
+		for i := 1; i < len(list); i++ {
+			days := int((list[i].Timestamp - list[i-1].Timestamp) / 86400)
+			if days >= minDays {
+				gaps = append(gaps, statsGap{
+					Camera: camera,
+					From:   list[i-1].Time().Format("2006-01-02"),
+					To:     list[i].Time().Format("2006-01-02"),
+					Days:   days,
+				})
+			}
+		}
+		last := list[len(list)-1]
+		if days := int((now.Unix() - last.Timestamp) / 86400); days >= minDays {
+			gaps = append(gaps, statsGap{
+				Camera:  camera,
+				From:    last.Time().Format("2006-01-02"),
+				To:      now.Format("2006-01-02"),
+				Days:    days,
+				Ongoing: true,
+			})
+		}
+	}
+	return gaps
+}
+
+// buildStats computes the requested reports.
+func buildStats(photos []cache.Photo, target *config.Target, cameras []string, reports map[string]bool, minGap int) *statsReport {
+	report := &statsReport{Total: &statsCount{Name: "total"}}
+	byCamera, byYear, byMonth, byCollection := statsGroups{}, statsGroups{}, statsGroups{}, statsGroups{}
+	for _, collection := range target.Collections {
+		byCollection[collection] = &statsCount{Name: collection}
+	}
+	for i := range photos {
+		photo := &photos[i]
+		report.Total.add(photo)
+		camera := photo.Camera
+		if camera == "" {
+			camera = "-"
+		}
+		byCamera.add(camera, photo)
+		year, month := "-", "-"
+		if photo.Timestamp != 0 {
+			t := photo.Time()
+			year, month = t.Format("2006"), t.Format("2006-01")
+		}
+		byYear.add(year, photo)
+		byMonth.add(month, photo)
+		for _, collection := range target.Collections {
+			if inDir(photo.Path, collection) {
+				byCollection.add(collection, photo)
+				break
+			}
+		}
+		if reports[reportNoExif] && !photo.HasExif() {
+			report.NoExif = append(report.NoExif, photo.Path)
+		}
+	}
+	if reports[reportLatest] {
+		report.Latest = latestPhotos(photos, cameras)
+	}
+	if reports[reportCameras] {
+		report.Cameras = byCamera.sorted()
+	}
+	if reports[reportYears] {
+		report.Years = byYear.sorted()
+	}
+	if reports[reportMonths] {
+		report.Months = byMonth.sorted()
+	}
+	if reports[reportCollections] {
+		report.Collections = byCollection.sorted()
+	}
+	if reports[reportNoExif] {
+		sort.Strings(report.NoExif)
+		if report.NoExif == nil {
+			report.NoExif = []string{}
+		}
+	}
+	if reports[reportGaps] {
+		report.Gaps = findGaps(photos, target.Cameras, minGap, time.Now())
+	}
+	return report
+}
+
+// printTitle prints the title of a report, underlined.
+func printTitle(title string) {
+	fmt.Printf("%s\n%s\n", title, strings.Repeat("=", len(title)))
+}
+
+func printCounts(title string, counts []statsCount, total *statsCount) {
+	printTitle(title)
+	rows := append(counts, *total)
+	nameLen := 0
+	for _, c := range rows {
+		if len(c.Name) > nameLen {
+			nameLen = len(c.Name)
+		}
+	}
+	fmt.Printf("%-*s  %8s  %8s  %10s\n", nameLen, "", "Photos", "Videos", "Size")
+	for _, c := range rows {
+		fmt.Printf("%-*s  %8d  %8d  %10s\n", nameLen, c.Name, c.Photos, c.Videos, formatBytes(c.Bytes))
+	}
+}
+
+func printStatsText(report *statsReport, reports map[string]bool, allCameras bool, minGap int) {
+	var sections []func()
+	if reports[reportLatest] {
+		sections = append(sections, func() {
+			title := "Latest photo per camera"
+			if allCameras {
+				title += " (all cameras)"
+			}
+			printTitle(title)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, entry := range report.Latest {
+				camera, t, path := entry.Camera, entry.Time, entry.Path
+				if camera == "" {
+					camera = "-"
+				}
+				if t == "" {
+					t = "-"
+				}
+				if path == "" {
+					path = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", camera, t, path)
+			}
+			w.Flush()
+		})
+	}
+	for _, section := range []struct {
+		report string
+		title  string
+		counts []statsCount
+	}{
+		{reportCameras, "Files per camera", report.Cameras},
+		{reportYears, "Files per year", report.Years},
+		{reportMonths, "Files per month", report.Months},
+		{reportCollections, "Files per collection", report.Collections},
+	} {
+		if reports[section.report] {
+			title, counts := section.title, section.counts
+			sections = append(sections, func() {
+				printCounts(title, counts, report.Total)
+			})
+		}
+	}
+	if reports[reportNoExif] {
+		sections = append(sections, func() {
+			printTitle(fmt.Sprintf("Files without Exif (%d)", len(report.NoExif)))
+			for _, path := range report.NoExif {
+				fmt.Println(path)
+			}
+		})
+	}
+	if reports[reportGaps] {
+		sections = append(sections, func() {
+			printTitle(fmt.Sprintf("Gaps of at least %d days", minGap))
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, gap := range report.Gaps {
+				switch {
+				case gap.From == "":
+					fmt.Fprintf(w, "%s\t-\t-\tno photos\n", gap.Camera)
+				case gap.Ongoing:
+					fmt.Fprintf(w, "%s\t%s\ttoday\t%d days\n", gap.Camera, gap.From, gap.Days)
+				default:
+					fmt.Fprintf(w, "%s\t%s\t%s\t%d days\n", gap.Camera, gap.From, gap.To, gap.Days)
+				}
+			}
+			w.Flush()
+		})
+	}
+	for i, section := range sections {
+		if i > 0 {
+			fmt.Println()
+		}
+		section()
+	}
+}
+
+// Stats shows interesting information and statistics about the
+// specified target. The information is inferred from the cache file,
+// which will be created if it doesn't exist or it will be updated if
+// it is too old.
+func Stats(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpStats, []string{"all"}, []string{"report", "gap", "format"})
+	allCameras := args.flag("all")
+	format := args.choice("format", "text", "json")
+	reports := make(map[string]bool)
+	for _, name := range args.values("report") {
+		switch {
+		case name == "all":
+			for _, r := range allReports {
+				reports[r] = true
+			}
+		case isReport(name):
+			reports[name] = true
+		default:
+			fmt.Printf("Invalid value for --report: %s (allowed: %s, all)\n", name, strings.Join(allReports, ", "))
+			ShowHelpStats()
+			os.Exit(1)
+		}
+	}
+	if len(reports) == 0 {
+		reports[reportLatest] = true
+	}
+	minGap, err := strconv.Atoi(args.value("gap", "30"))
+	if err != nil || minGap < 1 {
+		log.Fatal("Invalid --gap: " + args.value("gap", ""))
+	}
+	myCache := loadLocalCache(conf, target)
+	var photos []cache.Photo
+	for _, photo := range myCache.Photos {
+		if !photo.Ignored {
+			photos = append(photos, photo)
+		}
+	}
+	cameras := target.Cameras
+	if allCameras {
+		cameras = nil
+	}
+	report := buildStats(photos, target, cameras, reports, minGap)
+	if format == "json" {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Println(string(out))
+		return
+	}
+	printStatsText(report, reports, allCameras, minGap)
+}
+
+func isReport(name string) bool {
+	for _, r := range allReports {
+		if r == name {
+			return true
+		}
 	}
+	return false
 }
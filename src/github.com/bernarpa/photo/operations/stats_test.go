@@ -0,0 +1,40 @@
+package operations
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bernarpa/photo/cache"
+)
+
+func TestFindGaps(t *testing.T) {
+	// Taken at noon UTC, with the wall clock so that the dates don't
+	// depend on the time zone of the system
+	photo := func(camera, date string) cache.Photo {
+		d, err := time.Parse(cache.WallClockLayout, date+"T12:00:00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cache.Photo{Camera: camera, Timestamp: d.Unix(), WallClock: d.Format(cache.WallClockLayout)}
+	}
+	photos := []cache.Photo{
+		photo("A", "2021-03-01"),
+		photo("A", "2021-01-01"),
+		photo("A", "2021-01-10"),
+		photo("B", "2021-03-20"),
+		// Without timestamp, or from a camera that isn't listed
+		{Camera: "B"},
+		photo("C", "2020-01-01"),
+	}
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	want := []statsGap{
+		{Camera: "A", From: "2021-01-10", To: "2021-03-01", Days: 50},
+		{Camera: "A", From: "2021-03-01", To: "2021-04-01", Days: 31, Ongoing: true},
+		{Camera: "D", To: "2021-04-01", Ongoing: true},
+	}
+	got := findGaps(photos, []string{"D", "B", "A"}, 30, now)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findGaps = %+v, want %+v", got, want)
+	}
+}
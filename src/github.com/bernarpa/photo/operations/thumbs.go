@@ -0,0 +1,240 @@
+package operations
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+	"github.com/bernarpa/photo/ssh"
+)
+
+// defaultThumbSize is the default size of the thumbnails, in pixels.
+const defaultThumbSize = 256
+
+// ShowHelpThumbs prints the help for the thumbs operation.
+func ShowHelpThumbs() {
+	fmt.Println()
+	fmt.Println("Usage: photo thumbs <TARGET> [--size N] [--prune] [--format text|json]")
+	fmt.Println()
+	fmt.Println("   TARGET     one of the targets defined in config.json")
+	fmt.Println("   --size     size in pixels of the longest side of the thumbnails")
+	fmt.Println("              (default: 256)")
+	fmt.Println("   --prune    delete the thumbnails of the files no longer in the collection")
+	fmt.Println("   --format   text (default) prints a summary, json maps each file")
+	fmt.Println("              of the collection to its thumbnail")
+	fmt.Println()
+}
+
+// thumbSize returns the value of the --size option.
+func thumbSize(args *cmdArgs) int {
+	size, err := strconv.Atoi(args.value("size", strconv.Itoa(defaultThumbSize)))
+	if err != nil || size < 16 {
+		log.Fatal("Invalid --size: " + args.value("size", ""))
+	}
+	return size
+}
+
+// thumbEntry maps a file of the collection to its thumbnail.
+type thumbEntry struct {
+	Path      string `json:"path"`
+	Thumbnail string `json:"thumbnail"`
+}
+
+// LocalThumbs generates the missing thumbnails of the photos of a local
+// target, or of an SSH target on the remote system.
+func LocalThumbs(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpThumbs, nil, []string{"size", "format"})
+	size := thumbSize(args)
+	format := args.choice("format", "text", "json")
+	myCache, err := cache.Load(conf, target)
+	if err != nil {
+		log.Fatal("Error while loading the cache: " + err.Error())
+	}
+	created, failed := myCache.UpdateThumbnails(target.GetThumbnailsDir(size), size, conf.Workers)
+	if format == "text" {
+		fmt.Printf("%d thumbnails generated, %d errors\n", created, failed)
+	}
+}
+
+// missingThumbnails returns the thumbnails of the cache that aren't in
+// the specified directory, relative to it and with / as separator.
+func missingThumbnails(myCache *cache.Cache, dir string) []string {
+	var missing []string
+	seen := make(map[string]bool)
+	for i := range myCache.Photos {
+		photo := &myCache.Photos[i]
+		if !photo.HasThumbnail() || seen[photo.SHA256] {
+			continue
+		}
+		seen[photo.SHA256] = true
+		if _, err := os.Stat(photo.ThumbnailPath(dir)); err == nil {
+			continue
+		}
+		missing = append(missing, photo.SHA256[:2]+"/"+photo.SHA256+".jpg")
+	}
+	return missing
+}
+
+// extractThumbnails extracts a tar archive of thumbnails to dir.
+func extractThumbnails(archive []byte, dir string) (int, error) {
+	reader := tar.NewReader(bytes.NewReader(archive))
+	extracted := 0
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return extracted, nil
+		}
+		if err != nil {
+			return extracted, err
+		}
+		name := filepath.FromSlash(header.Name)
+		if header.Typeflag != tar.TypeReg || filepath.IsAbs(name) || strings.Contains(header.Name, "..") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return extracted, err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return extracted, err
+		}
+		_, err = io.Copy(f, reader)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+			return extracted, err
+		}
+		extracted++
+	}
+}
+
+// sshThumbs generates the missing thumbnails on an SSH target, then
+// downloads those that aren't available locally, in batches. The summaries
+// are printed only in text format, so that the JSON output isn't mixed
+// with them.
+func sshThumbs(conf *config.Config, target *config.Target, size int, format string) {
+	sshRun(conf, target, "localthumbs", "--size", strconv.Itoa(size), "--format", format)
+	myCache, err := cache.Load(conf, target)
+	if err != nil {
+		log.Fatal("Error while loading the cache: " + err.Error())
+	}
+	localDir := target.GetThumbnailsDir(size)
+	missing := missingThumbnails(myCache, localDir)
+	if len(missing) == 0 {
+		return
+	}
+	client, _, err := ssh.Connect(target)
+	if err != nil {
+		log.Fatal("SSH connection error: " + err.Error())
+	}
+	defer client.Close()
+	const batchSize = 200
+	downloaded := 0
+	for start := 0; start < len(missing); start += batchSize {
+		end := start + batchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		cmd := "cd " + ssh.Quote(target.GetRemoteThumbnailsDir(size)) + " && tar -cf -"
+		for _, name := range missing[start:end] {
+			cmd += " " + ssh.Quote(name)
+		}
+		// tar fails if some thumbnails are missing (e.g. unreadable
+		// photos), but it archives the others anyway
+		out, _ := ssh.Output(client, cmd)
+		n, err := extractThumbnails(out, localDir)
+		downloaded += n
+		if err != nil {
+			log.Printf("Warning: unable to extract the thumbnails: %s\n", err.Error())
+		}
+	}
+	if format == "text" {
+		fmt.Printf("%d thumbnails downloaded\n", downloaded)
+	}
+}
+
+// pruneThumbnails deletes the thumbnails of dir that don't belong to any
+// photo of the cache.
+func pruneThumbnails(myCache *cache.Cache, dir string) int {
+	used := make(map[string]bool)
+	for i := range myCache.Photos {
+		used[myCache.Photos[i].SHA256+".jpg"] = true
+	}
+	removed := 0
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || used[info.Name()] {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			removed++
+		}
+		return nil
+	})
+	return removed
+}
+
+// Thumbs generates the JPEG thumbnails of the photos of the target, which
+// are kept in a directory next to the cache, and skips the photos whose
+// thumbnail already exists.
+func Thumbs(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpThumbs, []string{"prune"}, []string{"size", "format"})
+	size := thumbSize(args)
+	format := args.choice("format", "text", "json")
+	dir := target.GetThumbnailsDir(size)
+	if target.TargetType == "local" {
+		myCache := loadLocalCache(conf, target)
+		created, failed := myCache.UpdateThumbnails(dir, size, conf.Workers)
+		if format == "text" {
+			fmt.Printf("%d thumbnails generated, %d errors\n", created, failed)
+		}
+	} else if target.TargetType == "ssh" {
+		// Makes sure that the cache is up to date before the remote run
+		loadLocalCache(conf, target)
+		sshThumbs(conf, target, size, format)
+	} else {
+		log.Fatal("Unsupported target type: " + target.TargetType)
+	}
+	myCache, err := cache.Load(conf, target)
+	if err != nil {
+		log.Fatal("Error while loading the cache: " + err.Error())
+	}
+	if args.flag("prune") {
+		removed := pruneThumbnails(myCache, dir)
+		if format == "text" {
+			fmt.Printf("%d unused thumbnails deleted\n", removed)
+		}
+	}
+	if format == "json" {
+		entries := []thumbEntry{}
+		for i := range myCache.Photos {
+			photo := &myCache.Photos[i]
+			thumb := photo.ThumbnailPath(dir)
+			if !photo.HasThumbnail() {
+				continue
+			}
+			if _, err := os.Stat(thumb); err == nil {
+				entries = append(entries, thumbEntry{Path: photo.Path, Thumbnail: thumb})
+			}
+		}
+		out, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("Thumbnails are in %s\n", dir)
+	}
+}
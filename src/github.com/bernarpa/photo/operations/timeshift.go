@@ -0,0 +1,218 @@
+package operations
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+	"github.com/bernarpa/photo/exiftool"
+)
+
+// ShowHelpTimeshift prints the help for the timeshift operation.
+func ShowHelpTimeshift() {
+	fmt.Println()
+	fmt.Println("Usage: photo timeshift <directory> --camera CAMERA --offset OFFSET [--from DATE] [--to DATE]")
+	fmt.Println("                       [--target TARGET [--update]] [--dry-run] [--format text|json]")
+	fmt.Println("       photo timeshift [directory] --reference FILE --sample FILE [...]")
+	fmt.Println()
+	fmt.Println("   directory    local directory with the photos to be corrected")
+	fmt.Println("   --camera     camera whose clock is wrong, as shown by photo info")
+	fmt.Println("   --offset     correction added to the capture time, e.g. +1h, -26h or")
+	fmt.Println("                -00:05:30")
+	fmt.Println("   --from       first day to correct (YYYY-MM-DD), as recorded by the camera")
+	fmt.Println("   --to         last day to correct (YYYY-MM-DD), as recorded by the camera")
+	fmt.Println("   --reference  photo taken with a camera whose clock is right")
+	fmt.Println("   --sample     photo of the same moment taken with the camera to correct:")
+	fmt.Println("                the offset is the difference between the two, and the camera")
+	fmt.Println("                is the one of the sample. Without directory it's only printed")
+	fmt.Println("   --target     target whose naming template and time zones are used")
+	fmt.Println("   --update     update the cache of TARGET afterwards")
+	fmt.Println("   --dry-run    print the planned changes without touching the filesystem")
+	fmt.Println("   --format     output format of --dry-run, text (default) or json")
+	fmt.Println()
+}
+
+// clockOffset matches an offset written as [+-]hh:mm[:ss].
+var clockOffset = regexp.MustCompile(`^([+-]?)(\d+):(\d{2})(?::(\d{2}))?$`)
+
+// parseOffset parses a time offset, either as a Go duration (e.g. -1h30m)
+// or as [+-]hh:mm[:ss]. The offset is rounded to the second, which is the
+// precision of the Exif dates.
+func parseOffset(value string) (time.Duration, error) {
+	if m := clockOffset.FindStringSubmatch(value); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		seconds, _ := strconv.Atoi(m[4])
+		offset := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return offset, nil
+	}
+	offset, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q, expected e.g. +1h30m or -01:30:00", value)
+	}
+	return offset.Round(time.Second), nil
+}
+
+// formatOffset formats an offset so that it can be passed to --offset.
+func formatOffset(offset time.Duration) string {
+	if offset < 0 {
+		return offset.String()
+	}
+	return "+" + offset.String()
+}
+
+// wallClock returns the capture time of a photo as shown by its camera.
+func wallClock(photo *cache.Photo) (time.Time, error) {
+	if photo.WallClock == "" {
+		return time.Time{}, fmt.Errorf("%s has no capture time", photo.Path)
+	}
+	return time.Parse(cache.WallClockLayout, photo.WallClock)
+}
+
+// calibrate computes the offset that brings the clock of the camera of
+// the sample photo in line with the one of the reference photo.
+func calibrate(referencePath, samplePath string, et *exiftool.Exiftool, target *config.Target) (time.Duration, string, error) {
+	var walls [2]time.Time
+	var camera string
+	for i, path := range []string{referencePath, samplePath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, "", err
+		}
+		photo, err := cache.AnalyzePhoto(path, info, et, target)
+		if err != nil {
+			return 0, "", err
+		}
+		walls[i], err = wallClock(&photo)
+		if err != nil {
+			return 0, "", err
+		}
+		camera = photo.Camera
+	}
+	if camera == "" {
+		return 0, "", fmt.Errorf("%s has no camera", samplePath)
+	}
+	return walls[0].Sub(walls[1]), camera, nil
+}
+
+// inDateRange checks whether the wall clock time of a photo falls
+// within the from and to days (YYYY-MM-DD), which can be empty.
+func inDateRange(photo *cache.Photo, from, to string) bool {
+	if len(photo.WallClock) < 10 {
+		return false
+	}
+	day := photo.WallClock[:10]
+	return (from == "" || day >= from) && (to == "" || day <= to)
+}
+
+// Timeshift corrects the capture time of the photos taken with a camera
+// whose clock was wrong: the offset is added to their date and time tags,
+// then they are renamed according to the corrected time.
+func Timeshift(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpTimeshift, []string{"update", "dry-run"}, []string{"camera", "offset", "from", "to", "reference", "sample", "target", "format"})
+	localDir := args.arg(0, "")
+	format := args.choice("format", "text", "json")
+	dryRun := args.flag("dry-run")
+	if name := args.value("target", ""); name != "" {
+		target = conf.GetTarget(name)
+		if target == nil {
+			log.Fatal("Target not found: " + name)
+		}
+	} else if args.flag("update") {
+		log.Fatal("--update requires --target")
+	}
+	from, to := args.value("from", ""), args.value("to", "")
+	for _, day := range []string{from, to} {
+		if _, err := time.Parse("2006-01-02", day); day != "" && err != nil {
+			log.Fatal("Invalid date, expected YYYY-MM-DD: " + day)
+		}
+	}
+	// The JSON plan of a dry run must be the only output
+	status := func(format string, v ...interface{}) {
+		fmt.Printf(format, v...)
+	}
+	if dryRun && format == "json" {
+		status = log.Printf
+	}
+	et := exiftool.Create(conf.Perl, conf.Workers)
+	defer et.Close()
+	camera := args.value("camera", "")
+	var offset time.Duration
+	reference, sample := args.value("reference", ""), args.value("sample", "")
+	switch {
+	case reference != "" && sample != "":
+		var sampleCamera string
+		var err error
+		offset, sampleCamera, err = calibrate(reference, sample, et, target)
+		if err != nil {
+			log.Fatal("Calibration error: " + err.Error())
+		}
+		if camera == "" {
+			camera = sampleCamera
+		}
+		if localDir == "" {
+			fmt.Printf("Offset of %s: %s\n", sampleCamera, formatOffset(offset))
+			return
+		}
+		status("Offset of %s: %s\n", sampleCamera, formatOffset(offset))
+	case reference != "" || sample != "":
+		log.Fatal("Calibration requires both --reference and --sample")
+	default:
+		var err error
+		offset, err = parseOffset(args.value("offset", ""))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	if localDir == "" || camera == "" {
+		ShowHelpTimeshift()
+		os.Exit(1)
+	}
+	if offset == 0 {
+		status("Nothing to do, the offset is zero\n")
+		return
+	}
+	localCache := cache.Create(target)
+	err := localCache.AnalyzeDir(localDir, conf.Workers, et, target)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	ops, done := fileOperations(dryRun, format)
+	renameTemplate := target.GetRenameTemplate()
+	counter := 0
+	for i := range localCache.Photos {
+		photo := &localCache.Photos[i]
+		if photo.Ignored || photo.Camera != camera || !inDateRange(photo, from, to) {
+			continue
+		}
+		err := ops.Modify(photo.Path, func(path string) error {
+			return et.ShiftDates(path, offset)
+		})
+		if err != nil {
+			log.Printf("Warning: unable to shift the dates of %s: %s\n", photo.Path, err.Error())
+			continue
+		}
+		oldPath := photo.Path
+		photo.Shift(offset)
+		counter++
+		photo.RenameToExif(renameTemplate, counter, ops)
+		if !dryRun {
+			fmt.Printf("%s -> %s\n", oldPath, photo.Path)
+		}
+	}
+	done()
+	if !dryRun {
+		fmt.Printf("%d files shifted by %s\n", counter, formatOffset(offset))
+		if args.flag("update") {
+			Update(conf, target)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package operations
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+	"github.com/bernarpa/photo/fileops"
+)
+
+// ShowHelpUndo prints the help for the undo operation.
+func ShowHelpUndo() {
+	fmt.Println()
+	fmt.Println("Usage: photo undo [journal] [--purge] [--target TARGET]")
+	fmt.Println()
+	fmt.Println("   journal    journal file written by filter, fix, import, similar, dupes,")
+	fmt.Println("              timeshift or geotag, by default")
+	fmt.Println("              the most recent one in the current directory")
+	fmt.Println("   --target   SSH target where the journal is, e.g. written by")
+	fmt.Println("              filter --remote; the journal must be specified")
+	fmt.Println("   --purge    don't undo anything, but delete the trash directory of the")
+	fmt.Println("              journal (by default, of all the journals in the current")
+	fmt.Println("              directory) to free the space used by the files removed by")
+	fmt.Println("              dupes and HEIC conversions and by the originals of the files")
+	fmt.Println("              modified by timeshift and geotag, which can no longer be")
+	fmt.Println("              restored afterwards")
+	fmt.Println()
+}
+
+// Undo reverts the changes recorded in a journal by replaying it in
+// reverse order. The entries that no longer apply are skipped.
+func Undo(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpUndo, []string{"purge"}, []string{"target"})
+	journalPath := args.arg(0, "")
+	if name := args.value("target", ""); name != "" {
+		target = conf.GetTarget(name)
+		if target == nil {
+			log.Fatal("Target not found: " + name)
+		}
+		if target.TargetType != "ssh" {
+			log.Fatal("--target requires an SSH target")
+		}
+		if journalPath == "" {
+			log.Fatal("--target requires the journal on the SSH target")
+		}
+		remoteArgs := []string{journalPath}
+		if args.flag("purge") {
+			remoteArgs = append(remoteArgs, "--purge")
+		}
+		sshExec(conf, target, "localundo", false, remoteArgs...)
+		return
+	}
+	if args.flag("purge") {
+		purgeTrashes(journalPath)
+		return
+	}
+	undoJournal(conf, journalPath)
+}
+
+// LocalUndo reverts the changes recorded in a journal of an SSH target
+// on the remote system, or purges its trash.
+func LocalUndo(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpUndo, []string{"purge"}, nil)
+	journalPath := args.arg(1, "")
+	if journalPath == "" {
+		log.Fatal("The journal is required")
+	}
+	if args.flag("purge") {
+		purgeTrashes(journalPath)
+		return
+	}
+	undoJournal(conf, journalPath)
+}
+
+// undoJournal replays a journal, by default the most recent one in the
+// working directory.
+func undoJournal(conf *config.Config, journalPath string) {
+	if journalPath == "" {
+		var err error
+		journalPath, err = fileops.LatestJournal(".")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	actions, err := fileops.LoadJournal(journalPath)
+	if err != nil {
+		log.Fatal("Journal loading error: " + err.Error())
+	}
+	fmt.Printf("Undoing %s\n", journalPath)
+	undone := 0
+	skipped := 0
+	var copies []string
+	for i := len(actions) - 1; i >= 0; i-- {
+		action := actions[i]
+		err := fileops.Undo(action)
+		if err != nil {
+			fmt.Printf("Skipped %s %s: %s\n", action.Op, action.Path, err.Error())
+			skipped++
+			continue
+		}
+		if action.Op == fileops.OpCopy {
+			copies = append(copies, action.Target)
+		}
+		undone++
+	}
+	if len(copies) > 0 {
+		dropFromCaches(conf, copies)
+	}
+	fileops.RemoveTrash(journalPath)
+	// Mark the journal as replayed, so that it isn't picked up again
+	err = fileops.MarkUndone(journalPath)
+	if err != nil {
+		log.Printf("Warning: unable to rename %s: %s\n", journalPath, err.Error())
+	}
+	fmt.Printf("%d changes undone, %d skipped\n", undone, skipped)
+}
+
+// dropFromCaches removes the copies deleted by undo, i.e. the photos
+// added by import, from the caches of the local targets.
+func dropFromCaches(conf *config.Config, paths []string) {
+	removed := make(map[string]bool)
+	for _, path := range paths {
+		removed[path] = true
+	}
+	for i := range conf.Targets {
+		target := &conf.Targets[i]
+		if target.TargetType != "local" {
+			continue
+		}
+		myCache, err := cache.Load(conf, target)
+		if err != nil {
+			continue
+		}
+		var kept []cache.Photo
+		for _, photo := range myCache.Photos {
+			if path, err := filepath.Abs(photo.Path); err != nil || !removed[path] {
+				kept = append(kept, photo)
+			}
+		}
+		if len(kept) == len(myCache.Photos) {
+			continue
+		}
+		dropped := len(myCache.Photos) - len(kept)
+		myCache.Photos = kept
+		err = myCache.Save(target.GetLocalCachePath())
+		if err != nil {
+			log.Printf("Warning: unable to update the cache of %s: %s\n", target.Name, err.Error())
+			continue
+		}
+		fmt.Printf("%d photos removed from the cache of %s\n", dropped, target.Name)
+	}
+}
+
+// purgeTrashes deletes the trash directory of a journal or, if journalPath
+// is empty, these of all the journals in the current directory.
+func purgeTrashes(journalPath string) {
+	var trashes []string
+	if journalPath != "" {
+		trashes = []string{fileops.TrashOf(journalPath)}
+	} else {
+		var err error
+		trashes, err = fileops.Trashes(".")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	var total int64
+	for _, trash := range trashes {
+		if _, err := os.Stat(trash); err != nil {
+			continue
+		}
+		files, size, err := fileops.PurgeTrash(trash)
+		if err != nil {
+			log.Printf("Warning: unable to delete %s: %s\n", trash, err.Error())
+			continue
+		}
+		fmt.Printf("Deleted %s: %d files, %s\n", trash, files, formatBytes(size))
+		total += size
+	}
+	fmt.Printf("%s freed\n", formatBytes(total))
+}
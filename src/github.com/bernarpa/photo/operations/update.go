@@ -1,8 +1,6 @@
 package operations
 
 import (
-	"compress/gzip"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -26,14 +24,12 @@ func ShowHelpUpdate() {
 	fmt.Println()
 }
 
-func sshUpdate(conf *config.Config, target *config.Target) {
-	// SSH connection
-	client, _, err := ssh.Connect(target)
-	if err != nil {
-		log.Fatal("SSH connection error: " + err.Error())
-	}
+// sshDeploy copies config.json, the Photo executable and, if withExiftool
+// is true, exiftool to the working directory of an SSH target, so that
+// Photo can run there. It returns the path of the remote executable.
+func sshDeploy(conf *config.Config, target *config.Target, client *ssh.Client, withExiftool bool) string {
 	// Ensures that the remote working dir exists
-	cmdEnsureWorkDir := fmt.Sprintf("test -d '%s' || mkdir -p '%s'", target.WorkDir, target.WorkDir)
+	cmdEnsureWorkDir := fmt.Sprintf("test -d %s || mkdir -p %s", ssh.Quote(target.WorkDir), ssh.Quote(target.WorkDir))
 	ssh.Exec(client, cmdEnsureWorkDir)
 	// Copies config.json to the remote work dir
 	exePath := utils.GetExePath()
@@ -45,13 +41,16 @@ func sshUpdate(conf *config.Config, target *config.Target) {
 	remoteExe := target.WorkDir + target.SSHExe
 	ssh.Copy(client, localExe, remoteExe)
 	// Ensures that the exe file is executable
-	ssh.Exec(client, fmt.Sprintf("chmod +x '%s'", remoteExe))
+	ssh.Exec(client, "chmod +x "+ssh.Quote(remoteExe))
+	if !withExiftool {
+		return remoteExe
+	}
 	// Create the exiftool directory structure
-	ssh.Exec(client, fmt.Sprintf("mkdir -p '%s'", strings.ReplaceAll(filepath.Join(target.WorkDir, "exiftool", "lib", "File"), conf.PathSeparator, target.SSHPathSeparator)))
-	ssh.Exec(client, fmt.Sprintf("mkdir -p '%s'", strings.ReplaceAll(filepath.Join(target.WorkDir, "exiftool", "lib", "Image", "ExifTool", "Charset"), conf.PathSeparator, target.SSHPathSeparator)))
-	ssh.Exec(client, fmt.Sprintf("mkdir -p '%s'", strings.ReplaceAll(filepath.Join(target.WorkDir, "exiftool", "lib", "Image", "ExifTool", "Lang"), conf.PathSeparator, target.SSHPathSeparator)))
+	ssh.Exec(client, "mkdir -p "+ssh.Quote(strings.ReplaceAll(filepath.Join(target.WorkDir, "exiftool", "lib", "File"), conf.PathSeparator, target.SSHPathSeparator)))
+	ssh.Exec(client, "mkdir -p "+ssh.Quote(strings.ReplaceAll(filepath.Join(target.WorkDir, "exiftool", "lib", "Image", "ExifTool", "Charset"), conf.PathSeparator, target.SSHPathSeparator)))
+	ssh.Exec(client, "mkdir -p "+ssh.Quote(strings.ReplaceAll(filepath.Join(target.WorkDir, "exiftool", "lib", "Image", "ExifTool", "Lang"), conf.PathSeparator, target.SSHPathSeparator)))
 	localExiftoolDir := filepath.Join(exePath, "exiftool")
-	err = filepath.Walk(localExiftoolDir, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(localExiftoolDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -67,10 +66,44 @@ func sshUpdate(conf *config.Config, target *config.Target) {
 	if err != nil {
 		log.Fatal(fmt.Sprintf("error walking the path %s: %s\n", localExiftoolDir, err.Error()))
 	}
-	// Runs photo localupdate TARGET on the SSH server
-	ssh.Exec(client, fmt.Sprintf("'%s' localupdate %s", remoteExe, target.Name))
+	return remoteExe
+}
+
+// sshRun deploys Photo to an SSH target, runs the specified operation
+// there (e.g. photo localupdate TARGET) and downloads the cache that
+// the operation has updated.
+func sshRun(conf *config.Config, target *config.Target, operation string, args ...string) {
+	sshExec(conf, target, operation, true, args...)
+}
+
+// sshExec works like sshRun, but the cache is downloaded only if
+// downloadCache is true, i.e. if the operation changes it. The standard
+// output and standard error of the remote operation are kept apart.
+func sshExec(conf *config.Config, target *config.Target, operation string, downloadCache bool, args ...string) {
+	// SSH connection
+	client, _, err := ssh.Connect(target)
+	if err != nil {
+		log.Fatal("SSH connection error: " + err.Error())
+	}
+	defer client.Close()
+	remoteExe := sshDeploy(conf, target, client, true)
+	// Runs photo OPERATION TARGET [args] on the SSH server
+	cmd := fmt.Sprintf("%s %s %s", ssh.Quote(remoteExe), operation, ssh.Quote(target.Name))
+	for _, arg := range args {
+		cmd += " " + ssh.Quote(arg)
+	}
+	err = ssh.Run(client, cmd)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("SSH command execution error: %s\nCommand was %s", err.Error(), cmd))
+	}
+	if !downloadCache {
+		return
+	}
 	// Downloads the newly generated cache
-	out := ssh.Exec(client, fmt.Sprintf("cat '%s'", target.GetRemoteCachePath()))
+	out, err := ssh.Output(client, "cat "+ssh.Quote(target.GetRemoteCachePath()))
+	if err != nil {
+		log.Fatal("Remote cache download error: " + err.Error())
+	}
 	localCache := target.GetLocalCachePath()
 	err = ioutil.WriteFile(localCache, out, 0644)
 	if err != nil {
@@ -78,29 +111,49 @@ func sshUpdate(conf *config.Config, target *config.Target) {
 	}
 }
 
-// LocalUpdate updates the cache for a local target.
+func sshUpdate(conf *config.Config, target *config.Target) {
+	sshRun(conf, target, "localupdate")
+}
+
+// LocalUpdate updates the cache for a local target. The entries of the
+// previous cache are reused for the files that haven't changed since the
+// last update.
 func LocalUpdate(conf *config.Config, target *config.Target) {
-	et := exiftool.Create(target.Perl)
+	et := exiftool.Create(target.Perl, conf.Workers)
+	defer et.Close()
 	log.Printf("exiftool created: %s\n", et.Perl)
+	previous := make(map[string]cache.Photo)
+	previousVersion := cache.Version
+	oldCache, err := cache.Load(conf, target)
+	if err == nil {
+		previous = oldCache.Index()
+		previousVersion = oldCache.Version
+		if previousVersion < cache.Version {
+			log.Printf("Previous cache has an outdated format, reading the metadata of all files again\n")
+		}
+	} else {
+		log.Printf("Previous cache not available, analyzing all files: %s\n", err.Error())
+	}
 	myCache := cache.Create(target)
+	var stats cache.UpdateStats
 	for _, targetDir := range target.Collections {
-		err := myCache.AnalyzeDir(targetDir, conf.Workers, et, target.Ignore)
+		err := myCache.UpdateDir(targetDir, conf.Workers, et, target, previous, previousVersion, &stats)
 		if err != nil {
 			log.Fatal("Cache update failure: " + err.Error())
 		}
 	}
-	jsonContent, err := json.Marshal(myCache)
-	localCacheFileName := target.GetLocalCachePath()
-	f, err := os.Create(localCacheFileName)
-	if err != nil {
-		log.Fatal("Cache file creation error: " + err.Error())
-	}
-	w := gzip.NewWriter(f)
-	defer w.Close()
-	_, err = w.Write(jsonContent)
+	stats.Removed = len(previous)
+	err = myCache.Save(target.GetLocalCachePath())
 	if err != nil {
 		log.Fatal("Cache file writing error: " + err.Error())
 	}
+	// The update can be run by the operations whose output is meant
+	// for other programs, e.g. find --format json
+	summary := fmt.Sprintf("Cache updated: %d added, %d changed, %d removed, %d unchanged", stats.Added, stats.Changed, stats.Removed, stats.Unchanged)
+	if stats.Migrated > 0 {
+		summary += fmt.Sprintf(", %d migrated", stats.Migrated)
+	}
+	log.Println(summary)
 }
 
 // Update the cache for the target specified on the command line.
@@ -0,0 +1,185 @@
+package operations
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+	"github.com/bernarpa/photo/imaging"
+	"github.com/bernarpa/photo/utils"
+)
+
+// verifySaveInterval is the number of verified files after which the
+// cache is saved, so that an interrupted verification can be resumed.
+const verifySaveInterval = 200
+
+// Outcomes of the verification of a file.
+const (
+	verifyOK       = iota // the content matches the recorded checksum
+	verifyRecorded        // the checksum has been recorded for the first time
+	verifyModified        // the file has been modified since the last update
+	verifySkipped         // the time budget is over
+	verifyFailed          // see the problem
+)
+
+// ShowHelpVerify prints the help for the verify operation.
+func ShowHelpVerify() {
+	fmt.Println()
+	fmt.Println("Usage: photo verify <TARGET> [--budget DURATION]")
+	fmt.Println()
+	fmt.Println("   TARGET     one of the targets defined in config.json")
+	fmt.Println("   --budget   stop after DURATION (e.g. 30m or 2h) and resume from that")
+	fmt.Println("              point the next time; by default all the files are verified")
+	fmt.Println()
+}
+
+type verifyResult struct {
+	index   int
+	outcome int
+	sha256  string
+	problem string
+}
+
+// verifyPhoto checks that a file still exists, that its content matches
+// the recorded checksum unless the file has been legitimately modified
+// (i.e. its modification time has changed), and that JPEGs still decode.
+func verifyPhoto(photo cache.Photo) (int, string, string) {
+	info, err := os.Stat(photo.Path)
+	if os.IsNotExist(err) {
+		return verifyFailed, "", "vanished"
+	}
+	if err != nil {
+		return verifyFailed, "", "unreadable: " + err.Error()
+	}
+	if info.Size() != photo.Size && info.ModTime().UnixNano() == photo.ModTime {
+		return verifyFailed, "", fmt.Sprintf("size changed from %d to %d bytes, modification time unchanged", photo.Size, info.Size())
+	}
+	if info.ModTime().UnixNano() != photo.ModTime {
+		return verifyModified, "", ""
+	}
+	hash, err := utils.SHA256(photo.Path)
+	if err != nil {
+		return verifyFailed, "", "unreadable: " + err.Error()
+	}
+	if photo.SHA256 != "" && hash != photo.SHA256 {
+		return verifyFailed, "", "content changed, modification time unchanged"
+	}
+	ext := strings.ToLower(filepath.Ext(photo.Path))
+	if ext == ".jpg" || ext == ".jpeg" {
+		if _, err := imaging.Decode(photo.Path); err != nil {
+			return verifyFailed, hash, "doesn't decode: " + err.Error()
+		}
+	}
+	if photo.SHA256 == "" {
+		return verifyRecorded, hash, ""
+	}
+	return verifyOK, hash, ""
+}
+
+func workerVerifyPhoto(jobs <-chan int, results chan<- verifyResult, photos []cache.Photo, deadline time.Time) {
+	for i := range jobs {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			results <- verifyResult{index: i, outcome: verifySkipped}
+			continue
+		}
+		outcome, hash, problem := verifyPhoto(photos[i])
+		results <- verifyResult{i, outcome, hash, problem}
+	}
+}
+
+// LocalVerify verifies the files of a target against the checksums of
+// its cache, recording the missing checksums. The files that haven't been
+// verified for the longest time are checked first, so that a verification
+// limited by --budget resumes where the previous one stopped.
+func LocalVerify(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpVerify, nil, []string{"budget"})
+	var deadline time.Time
+	if budget := args.value("budget", ""); budget != "" {
+		duration, err := time.ParseDuration(budget)
+		if err != nil || duration <= 0 {
+			fmt.Println("Invalid value for --budget: " + budget)
+			ShowHelpVerify()
+			os.Exit(1)
+		}
+		deadline = time.Now().Add(duration)
+	}
+	myCache, err := cache.Load(conf, target)
+	if err != nil {
+		log.Fatal("Error while loading the cache: " + err.Error())
+	}
+	var queue []int
+	for i, photo := range myCache.Photos {
+		if !photo.Ignored {
+			queue = append(queue, i)
+		}
+	}
+	sort.SliceStable(queue, func(a, b int) bool {
+		return myCache.Photos[queue[a]].Verified < myCache.Photos[queue[b]].Verified
+	})
+	jobs := make(chan int, len(queue))
+	results := make(chan verifyResult, len(queue))
+	for w := 0; w < conf.Workers; w++ {
+		go workerVerifyPhoto(jobs, results, myCache.Photos, deadline)
+	}
+	for _, i := range queue {
+		jobs <- i
+	}
+	close(jobs)
+	counts := make(map[int]int)
+	var problems []string
+	for r := 0; r < len(queue); r++ {
+		result := <-results
+		counts[result.outcome]++
+		photo := &myCache.Photos[result.index]
+		switch result.outcome {
+		case verifyOK, verifyRecorded:
+			photo.SHA256 = result.sha256
+			photo.Verified = time.Now().Unix()
+		case verifyFailed:
+			// The Verified timestamp isn't updated, so that the file
+			// is checked again first the next time
+			problems = append(problems, fmt.Sprintf("%s: %s", photo.Path, result.problem))
+		}
+		if (r+1)%verifySaveInterval == 0 {
+			if err := myCache.Save(target.GetLocalCachePath()); err != nil {
+				log.Fatal("Cache file writing error: " + err.Error())
+			}
+		}
+	}
+	err = myCache.Save(target.GetLocalCachePath())
+	if err != nil {
+		log.Fatal("Cache file writing error: " + err.Error())
+	}
+	sort.Strings(problems)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("%d verified, %d checksums recorded, %d modified since the last update, %d problems, %d left for the next run\n",
+		counts[verifyOK], counts[verifyRecorded], counts[verifyModified], counts[verifyFailed], counts[verifySkipped])
+}
+
+// Verify checks the integrity of the files of the target specified on
+// the command line.
+func Verify(conf *config.Config, target *config.Target) {
+	if target.TargetType == "local" {
+		// Make sure that the cache exists and includes the new files
+		loadLocalCache(conf, target)
+		LocalVerify(conf, target)
+	} else if target.TargetType == "ssh" {
+		args := mustParseArgs(ShowHelpVerify, nil, []string{"budget"})
+		loadLocalCache(conf, target)
+		var remoteArgs []string
+		if budget := args.value("budget", ""); budget != "" {
+			remoteArgs = append(remoteArgs, "--budget", budget)
+		}
+		sshRun(conf, target, "localverify", remoteArgs...)
+	} else {
+		log.Fatal("Unsupported target type: " + target.TargetType)
+	}
+}
@@ -0,0 +1,389 @@
+package operations
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bernarpa/photo/cache"
+	"github.com/bernarpa/photo/config"
+	"github.com/bernarpa/photo/exiftool"
+	"github.com/bernarpa/photo/fileops"
+	"github.com/fsnotify/fsnotify"
+)
+
+// ShowHelpWatch prints the help for the watch operation.
+func ShowHelpWatch() {
+	fmt.Println()
+	fmt.Println("Usage: photo watch <TARGET> <directory> [--settle DURATION] [--poll DURATION]")
+	fmt.Println("                   [--match both|metadata|content] [--log text|json]")
+	fmt.Println()
+	fmt.Println("   TARGET     one of the targets defined in config.json")
+	fmt.Println("   directory  local directory where the new photos arrive")
+	fmt.Println("   --settle   how long a file must stay unchanged before being filtered,")
+	fmt.Println("              e.g. 30s or 2m (default: 10s)")
+	fmt.Println("   --poll     scan the directory at the specified interval (e.g. 5s) instead")
+	fmt.Println("              of waiting for the notifications of the file system, which")
+	fmt.Println("              may not work on network shares; polling is also used when")
+	fmt.Println("              the notifications aren't available")
+	fmt.Println("   --match    how photos already in the target are recognized, as in filter")
+	fmt.Println("   --log      log format, text (default) or json (one object per line)")
+	fmt.Println()
+	fmt.Println("   The files are filtered as by photo filter and the changes are recorded in")
+	fmt.Println("   a journal for each batch. The cache of the target is read again when it")
+	fmt.Println("   changes, e.g. after photo update, but it isn't updated by watch itself.")
+	fmt.Println("   Stop watching with Ctrl+C.")
+	fmt.Println()
+}
+
+// defaultPoll is the polling interval used when the notifications of the
+// file system aren't available.
+const defaultPoll = 5 * time.Second
+
+// watchLogger writes structured log lines to the standard output, either
+// as key=value pairs or as JSON objects.
+type watchLogger struct {
+	json bool
+}
+
+// log writes an event, followed by pairs of field names and values.
+func (l *watchLogger) log(event string, fields ...interface{}) {
+	now := time.Now().Format(time.RFC3339)
+	var sb strings.Builder
+	if l.json {
+		sb.WriteString(`{"time":` + strconv.Quote(now) + `,"event":` + strconv.Quote(event))
+		for i := 0; i+1 < len(fields); i += 2 {
+			value, err := json.Marshal(fields[i+1])
+			if err != nil {
+				value, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+			}
+			sb.WriteString("," + strconv.Quote(fmt.Sprint(fields[i])) + ":" + string(value))
+		}
+		sb.WriteString("}")
+	} else {
+		sb.WriteString(now + " event=" + event)
+		for i := 0; i+1 < len(fields); i += 2 {
+			value := fmt.Sprint(fields[i+1])
+			if value == "" || strings.ContainsAny(value, " \t\"=") {
+				value = strconv.Quote(value)
+			}
+			sb.WriteString(fmt.Sprintf(" %v=%s", fields[i], value))
+		}
+	}
+	fmt.Println(sb.String())
+}
+
+// watchedFile is the state of a file of the watched directory.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+	// since is when the file was found with this size and modification time
+	since time.Time
+}
+
+func (f *watchedFile) sameAs(info os.FileInfo) bool {
+	return f.size == info.Size() && f.modTime.Equal(info.ModTime())
+}
+
+// inboxWatcher keeps track of the files of a directory that are waiting
+// to be filtered.
+type inboxWatcher struct {
+	dir     string
+	ignores []string
+	settle  time.Duration
+	logger  *watchLogger
+	// index contains the photos of the cache of the target and the new
+	// photos filtered since the start, which are in filtered
+	index     *targetIndex
+	filtered  []cache.Photo
+	cacheTime time.Time
+	pending   map[string]*watchedFile
+	// done are the files already filtered that are still in the directory,
+	// e.g. because they couldn't be moved, which aren't filtered again
+	// unless they change
+	done map[string]*watchedFile
+}
+
+// skipDir checks whether a subdirectory mustn't be watched: the trash
+// of the journals and the directories where the photos are filtered.
+func (w *inboxWatcher) skipDir(path string) bool {
+	if fileops.IsTrash(path) {
+		return true
+	}
+	if filepath.Dir(path) == filepath.Clean(w.dir) {
+		switch filepath.Base(path) {
+		case duplicatesDirName, conflictsDirName, noExifDirName, newDirName:
+			return true
+		}
+	}
+	return false
+}
+
+// walk calls fn for each directory or supported file of a directory of
+// the watched one, skipping the paths ignored by the target.
+func (w *inboxWatcher) walk(root string, fn func(path string, info os.FileInfo)) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files can disappear while walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && path != w.dir && w.skipDir(path) {
+			return filepath.SkipDir
+		}
+		for _, ignore := range w.ignores {
+			if strings.Contains(path, ignore) {
+				return nil
+			}
+		}
+		if info.IsDir() || cache.IsSupported(path) {
+			fn(path, info)
+		}
+		return nil
+	})
+}
+
+// scan looks for new or changed files in the watched directory.
+func (w *inboxWatcher) scan(now time.Time) error {
+	found := make(map[string]bool)
+	err := w.walk(w.dir, func(path string, info os.FileInfo) {
+		if info.IsDir() {
+			return
+		}
+		found[path] = true
+		if done, exists := w.done[path]; exists {
+			if done.sameAs(info) {
+				return
+			}
+			delete(w.done, path)
+		}
+		file, exists := w.pending[path]
+		if !exists {
+			w.logger.log("detected", "path", path, "size", info.Size())
+		}
+		if !exists || !file.sameAs(info) {
+			w.pending[path] = &watchedFile{size: info.Size(), modTime: info.ModTime(), since: now}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	// Forgets the files that have been moved or deleted meanwhile
+	for _, files := range []map[string]*watchedFile{w.pending, w.done} {
+		for path := range files {
+			if !found[path] {
+				delete(files, path)
+			}
+		}
+	}
+	return nil
+}
+
+// ready returns the pending files that haven't changed for the settle
+// period, which are checked once more before being returned.
+func (w *inboxWatcher) ready(now time.Time) []string {
+	var paths []string
+	for path, file := range w.pending {
+		if now.Sub(file.since) < w.settle {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(w.pending, path)
+			continue
+		}
+		if !file.sameAs(info) {
+			w.pending[path] = &watchedFile{size: info.Size(), modTime: info.ModTime(), since: now}
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// loadCache indexes the cache of the target, if it has been written since
+// it was last loaded, e.g. by a scheduled update. The current index is
+// kept if the cache can't be read.
+func (w *inboxWatcher) loadCache(conf *config.Config, target *config.Target) {
+	info, err := os.Stat(target.GetLocalCachePath())
+	if err != nil {
+		w.logger.log("error", "message", "cache not available: "+err.Error())
+		return
+	}
+	if !info.ModTime().After(w.cacheTime) {
+		return
+	}
+	targetCache, err := cache.Load(conf, target)
+	if err != nil {
+		w.logger.log("error", "message", "cache loading error: "+err.Error())
+		return
+	}
+	w.index = newTargetIndex(targetCache)
+	for _, photo := range w.filtered {
+		w.index.add(photo)
+	}
+	w.cacheTime = info.ModTime()
+	w.logger.log("cache", "photos", len(targetCache.Photos))
+}
+
+// filter filters the files that are ready, recording the changes in a
+// new journal.
+func (w *inboxWatcher) filter(conf *config.Config, target *config.Target, paths []string, match string, et *exiftool.Exiftool) {
+	w.loadCache(conf, target)
+	w.logger.log("filtering", "files", len(paths))
+	for _, path := range paths {
+		w.done[path] = w.pending[path]
+		delete(w.pending, path)
+	}
+	journal, err := fileops.CreateJournal(".")
+	if err != nil {
+		w.logger.log("error", "message", "journal creation error: "+err.Error())
+		return
+	}
+	localCache := cache.Create(target)
+	localCache.AnalyzeFiles(paths, conf.Workers, et, target)
+	result := filterPhotos(target, w.index, w.dir, localCache.Photos, match, journal, et, false)
+	journal.Close()
+	for _, photo := range result.New {
+		// The next files are recognized as duplicates of the new ones
+		w.index.add(photo)
+		w.filtered = append(w.filtered, photo)
+		w.logger.log("new", "path", photo.Path)
+	}
+	w.logger.log("filtered", "new", len(result.New), "duplicates", result.Duplicates,
+		"conflicts", result.Conflicts, "noexif", result.NoExif, "failed", len(paths)-len(localCache.Photos))
+	if !journal.Empty() {
+		w.logger.log("journal", "path", journal.Path)
+	}
+}
+
+// watchDirs adds a directory and its subdirectories to the notifications
+// of the file system.
+func (w *inboxWatcher) watchDirs(notifier *fsnotify.Watcher, root string) error {
+	var failures []string
+	err := w.walk(root, func(path string, info os.FileInfo) {
+		if info.IsDir() {
+			if err := notifier.Add(path); err != nil {
+				failures = append(failures, err.Error())
+			}
+		}
+	})
+	if err == nil && len(failures) > 0 {
+		err = fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return err
+}
+
+func parseDurationOption(args *cmdArgs, name string, def string) time.Duration {
+	d, err := time.ParseDuration(args.value(name, def))
+	if err != nil || d < 0 {
+		log.Fatal("Invalid --" + name + ": " + args.value(name, def))
+	}
+	return d
+}
+
+// Watch filters the new photos of a local directory as soon as they're
+// completely written, until it's interrupted.
+func Watch(conf *config.Config, target *config.Target) {
+	args := mustParseArgs(ShowHelpWatch, nil, []string{"settle", "poll", "match", "log"})
+	dir := args.arg(1, "")
+	if dir == "" {
+		ShowHelpWatch()
+		os.Exit(1)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		log.Fatal("Not a directory: " + dir)
+	}
+	settle := parseDurationOption(args, "settle", "10s")
+	poll := parseDurationOption(args, "poll", "0s")
+	match := args.choice("match", matchBoth, matchMetadata, matchContent)
+	logger := &watchLogger{json: args.choice("log", "text", "json") == "json"}
+	w := &inboxWatcher{
+		dir:     filepath.Clean(dir),
+		ignores: target.Ignore,
+		settle:  settle,
+		logger:  logger,
+		pending: make(map[string]*watchedFile),
+		done:    make(map[string]*watchedFile),
+	}
+	// Makes sure that the cache of the target is up to date before the
+	// files start arriving
+	w.index = newTargetIndex(loadLocalCache(conf, target))
+	if info, err := os.Stat(target.GetLocalCachePath()); err == nil {
+		w.cacheTime = info.ModTime()
+	}
+	et := exiftool.Create(conf.Perl, conf.Workers)
+	defer et.Close()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var notifier *fsnotify.Watcher
+	var events chan fsnotify.Event
+	var notifyErrors chan error
+	if poll == 0 {
+		var err error
+		notifier, err = fsnotify.NewWatcher()
+		if err == nil {
+			defer notifier.Close()
+			err = w.watchDirs(notifier, w.dir)
+		}
+		if err != nil {
+			logger.log("polling", "reason", err.Error())
+			poll = defaultPoll
+		} else {
+			events, notifyErrors = notifier.Events, notifier.Errors
+		}
+	}
+	// Without notifications the directory is scanned at each tick,
+	// otherwise only after a change
+	tick := time.Second
+	mode := "notify"
+	if poll > 0 {
+		tick, mode = poll, "poll"
+	}
+	logger.log("started", "dir", w.dir, "target", target.Name, "mode", mode, "settle", settle.String())
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	changed := true
+	for {
+		select {
+		case sig := <-signals:
+			logger.log("stopped", "signal", sig.String(), "pending", len(w.pending))
+			return
+		case event := <-events:
+			changed = true
+			if event.Op&fsnotify.Create != 0 {
+				// New subdirectories must be watched as well
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() && !w.skipDir(event.Name) {
+					if err := w.watchDirs(notifier, event.Name); err != nil {
+						logger.log("error", "message", err.Error())
+					}
+				}
+			}
+		case err := <-notifyErrors:
+			logger.log("error", "message", err.Error())
+		case <-ticker.C:
+			now := time.Now()
+			if changed || poll > 0 {
+				changed = false
+				if err := w.scan(now); err != nil {
+					logger.log("error", "message", err.Error())
+					continue
+				}
+			}
+			if paths := w.ready(now); len(paths) > 0 {
+				w.filter(conf, target, paths, match, et)
+			}
+		}
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	// Embed the time zone database, which Windows lacks
+	_ "time/tzdata"
 
 	"github.com/bernarpa/photo/operations"
 )
@@ -11,7 +13,7 @@ func showHelp() {
 	fmt.Println()
 	fmt.Println("Usage: photo <OPERATION>")
 	fmt.Println()
-	fmt.Println("   OPERATION     available options: help, fix, filter, info, ignore, stats, update")
+	fmt.Println("   OPERATION     available options: help, dupes, find, fix, filter, geotag, import, info, ignore, report, serve, similar, stats, thumbs, timeshift, undo, update, verify, watch")
 	fmt.Println()
 }
 
@@ -31,12 +33,48 @@ func main() {
 		operations.RunCommandFunction(operations.Stats, operations.ShowHelpStats, true)
 	case "filter":
 		operations.RunCommandFunction(operations.Filter, operations.ShowHelpFilter, true)
+	case "localfilter":
+		operations.RunCommandFunction(operations.LocalFilter, operations.ShowHelpFilter, true)
+	case "import":
+		operations.RunCommandFunction(operations.Import, operations.ShowHelpImport, true)
+	case "localstat":
+		operations.RunCommandFunction(operations.LocalStat, operations.ShowHelpImport, true)
+	case "dupes":
+		operations.RunCommandFunction(operations.Dupes, operations.ShowHelpDupes, true)
+	case "find":
+		operations.RunCommandFunction(operations.Find, operations.ShowHelpFind, true)
 	case "fix":
 		operations.RunCommandFunction(operations.Fix, operations.ShowHelpFix, false)
 	case "info":
 		operations.RunCommandFunction(operations.Info, operations.ShowHelpInfo, false)
 	case "ignore":
 		operations.RunCommandFunction(operations.Ignore, operations.ShowHelpIgnore, false)
+	case "report":
+		operations.RunCommandFunction(operations.Report, operations.ShowHelpReport, true)
+	case "serve":
+		operations.RunCommandFunction(operations.Serve, operations.ShowHelpServe, true)
+	case "similar":
+		operations.RunCommandFunction(operations.Similar, operations.ShowHelpSimilar, true)
+	case "localphash":
+		operations.RunCommandFunction(operations.LocalPHash, operations.ShowHelpSimilar, true)
+	case "verify":
+		operations.RunCommandFunction(operations.Verify, operations.ShowHelpVerify, true)
+	case "localverify":
+		operations.RunCommandFunction(operations.LocalVerify, operations.ShowHelpVerify, true)
+	case "geotag":
+		operations.RunCommandFunction(operations.Geotag, operations.ShowHelpGeotag, false)
+	case "timeshift":
+		operations.RunCommandFunction(operations.Timeshift, operations.ShowHelpTimeshift, false)
+	case "thumbs":
+		operations.RunCommandFunction(operations.Thumbs, operations.ShowHelpThumbs, true)
+	case "localthumbs":
+		operations.RunCommandFunction(operations.LocalThumbs, operations.ShowHelpThumbs, true)
+	case "watch":
+		operations.RunCommandFunction(operations.Watch, operations.ShowHelpWatch, true)
+	case "undo":
+		operations.RunCommandFunction(operations.Undo, operations.ShowHelpUndo, false)
+	case "localundo":
+		operations.RunCommandFunction(operations.LocalUndo, operations.ShowHelpUndo, true)
 	default:
 		fmt.Printf("Invalid operation: %s\n", op)
 		showHelp()
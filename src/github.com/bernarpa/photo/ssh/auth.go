@@ -0,0 +1,170 @@
+package ssh
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bernarpa/photo/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// expandHome replaces a leading ~ with the home directory of the user.
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~\\") {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+// authMethods returns the authentication methods configured for the
+// target, in the order they are tried: ssh-agent, private keys, password.
+func authMethods(target *config.Target) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if target.SSHAgent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, errors.New("ssh_agent is enabled but SSH_AUTH_SOCK is not set")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to ssh-agent: %s", err.Error())
+		}
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+	var signers []ssh.Signer
+	for _, keyFile := range target.SSHKeyFiles {
+		pem, err := ioutil.ReadFile(expandHome(keyFile))
+		if err != nil {
+			return nil, err
+		}
+		var signer ssh.Signer
+		if target.SSHKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(target.SSHKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to load private key %s: %s", keyFile, err.Error())
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if target.SSHPassword != "" {
+		methods = append(methods, ssh.Password(target.SSHPassword))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no SSH authentication method configured")
+	}
+	return methods, nil
+}
+
+// knownHostsPath returns the known_hosts file of the target,
+// by default ~/.ssh/known_hosts.
+func knownHostsPath(target *config.Target) string {
+	if target.SSHKnownHosts != "" {
+		return expandHome(target.SSHKnownHosts)
+	}
+	return expandHome(filepath.Join("~", ".ssh", "known_hosts"))
+}
+
+// hostKeyCallback checks the host keys against the known_hosts file.
+// Unknown hosts are added to the file after asking the user to trust
+// them, whereas a key that doesn't match the known one is an error.
+// It also returns the key algorithms already known for the host, so
+// that the server is asked to present a key of one of these types.
+func hostKeyCallback(target *config.Target, host string) (ssh.HostKeyCallback, []string, error) {
+	path := knownHostsPath(target)
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	f.Close()
+	check, err := knownhosts.New(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf("the host key of %s has changed (%s %s), it doesn't match %s:%d: "+
+				"someone could be eavesdropping, remove the old key from %s only if you know why it changed",
+				hostname, key.Type(), ssh.FingerprintSHA256(key), keyErr.Want[0].Filename, keyErr.Want[0].Line, path)
+		}
+		return trustOnFirstUse(path, hostname, remote, key)
+	}
+	return callback, knownAlgorithms(check, host), nil
+}
+
+// knownAlgorithms returns the types of the keys known for a host. It uses
+// the trick of checking a throwaway key, whose KeyError lists the known keys.
+func knownAlgorithms(check ssh.HostKeyCallback, host string) []string {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil
+	}
+	addr := &net.TCPAddr{IP: net.IPv4zero}
+	err = check(host, addr, signer.PublicKey())
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		algorithms = append(algorithms, known.Key.Type())
+		if known.Key.Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+	}
+	return algorithms
+}
+
+// trustOnFirstUse asks the user whether to trust an unknown host key
+// and, if so, appends it to the known_hosts file.
+func trustOnFirstUse(path string, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	fmt.Printf("The authenticity of host %s (%s) can't be established.\n", hostname, remote.String())
+	fmt.Printf("%s key fingerprint is %s.\n", key.Type(), ssh.FingerprintSHA256(key))
+	fmt.Print("Are you sure you want to continue connecting (yes/no)? ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(strings.ToLower(answer)) != "yes" {
+		return fmt.Errorf("host key verification failed for %s", hostname)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	addresses := []string{knownhosts.Normalize(hostname)}
+	_, err = fmt.Fprintln(f, knownhosts.Line(addresses, key))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Permanently added %s to %s.\n", hostname, path)
+	return nil
+}
@@ -2,21 +2,42 @@ package ssh
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/bernarpa/photo/config"
 	"github.com/tmc/scp"
 	"golang.org/x/crypto/ssh"
 )
 
-// Connect establishes a new SSH connection.
+// Client is an SSH connection.
+type Client = ssh.Client
+
+// Quote quotes a string for the remote (POSIX) shell.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Connect establishes a new SSH connection. The host key of the server is
+// verified against the known_hosts file configured for the target.
 func Connect(target *config.Target) (*ssh.Client, *ssh.Session, error) {
-	sshConfig := &ssh.ClientConfig{
-		User: target.SSHUser,
-		Auth: []ssh.AuthMethod{ssh.Password(target.SSHPassword)},
+	auth, err := authMethods(target)
+	if err != nil {
+		return nil, nil, err
 	}
-	sshConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
 	host := target.SSHHost + ":" + target.SSHPort
+	hostKeyCallback, algorithms, err := hostKeyCallback(target, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	sshConfig := &ssh.ClientConfig{
+		User:              target.SSHUser,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: algorithms,
+	}
 	client, err := ssh.Dial("tcp", host, sshConfig)
 	if err != nil {
 		return nil, nil, err
@@ -42,6 +63,44 @@ func Exec(client *ssh.Client, cmd string) []byte {
 	return out
 }
 
+// Output executes a command on an SSH server and returns its standard
+// output, which is kept apart from the standard error, e.g. for binary
+// data. The output is returned even if the command fails.
+func Output(client *ssh.Client, cmd string) ([]byte, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	return session.Output(cmd)
+}
+
+// Stream executes a command on an SSH server and writes its standard
+// output to w as it arrives, e.g. to send a remote file over HTTP.
+func Stream(client *ssh.Client, cmd string, w io.Writer) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdout = w
+	return session.Run(cmd)
+}
+
+// Run executes a command on an SSH server, forwarding its standard output
+// and standard error to the local ones as they arrive, so that they can
+// be told apart (e.g. JSON printed by a remote Photo and its log).
+func Run(client *ssh.Client, cmd string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	return session.Run(cmd)
+}
+
 // Copy copies a file on the SSH server or exits the program in case of failure.
 func Copy(client *ssh.Client, localFile string, remoteFile string) {
 	session, err := client.NewSession()
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"io"
 	"log"
@@ -45,6 +46,55 @@ func MD5(path string) (string, error) {
 	return md5Hash, nil
 }
 
+// SHA256 computes the SHA-256 hash of a file.
+func SHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// SHA256AndMD5 computes both the SHA-256 and the MD5 hash of a file,
+// reading it only once.
+func SHA256AndMD5(path string) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+	sha256Hash := sha256.New()
+	md5Hash := md5.New()
+	if _, err := io.Copy(io.MultiWriter(sha256Hash, md5Hash), file); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(sha256Hash.Sum(nil)), hex.EncodeToString(md5Hash.Sum(nil)), nil
+}
+
+// SameContent checks whether two files have the same content.
+// It returns false if any of them cannot be read.
+func SameContent(path1, path2 string) bool {
+	info1, err := os.Stat(path1)
+	if err != nil {
+		return false
+	}
+	info2, err := os.Stat(path2)
+	if err != nil || info1.Size() != info2.Size() {
+		return false
+	}
+	md5a, err := MD5(path1)
+	if err != nil {
+		return false
+	}
+	md5b, err := MD5(path2)
+	return err == nil && md5a == md5b
+}
+
 // HeicToJPEG converts an HEIC image to a JPEG image.
 // It requires ImageMagick in the PATH (convert for Unix platforms, magick.exe for Windows).
 func HeicToJPEG(heicFile, jpegFile string) error {
@@ -56,3 +106,32 @@ func HeicToJPEG(heicFile, jpegFile string) error {
 	}
 	return cmd.Run()
 }
+
+// CopyFile copies a file, preserving its modification time.
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}